@@ -0,0 +1,68 @@
+package nbt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Bedrock Edition stores level.dat as uncompressed little-endian NBT after an 8 byte header: the
+// storage version of the file and the length of the NBT data, both little-endian 32 bit integers.
+
+// Reads the header of a Bedrock Edition level.dat file.
+func ReadBedrockHeader(in io.Reader) (version int32, length uint32, err error) {
+	var header [8]byte
+	if _, err := io.ReadFull(in, header[:]); err != nil {
+		return 0, 0, err
+	}
+	return int32(binary.LittleEndian.Uint32(header[:4])), binary.LittleEndian.Uint32(header[4:]), nil
+}
+
+// Writes the header of a Bedrock Edition level.dat file.
+func WriteBedrockHeader(out io.Writer, version int32, length uint32) error {
+	var header [8]byte
+	binary.LittleEndian.PutUint32(header[:4], uint32(version))
+	binary.LittleEndian.PutUint32(header[4:], length)
+	_, err := out.Write(header[:])
+	return err
+}
+
+// Reads a Bedrock Edition level.dat file and stores its root tag in the value pointed to by v.
+// Like a Decoder, it skips compound entries that have no matching struct field, since level.dat
+// gains new entries with every version. It returns the storage version from the header.
+func UnmarshalBedrockLevel(in io.Reader, v interface{}) (version int32, err error) {
+	version, length, err := ReadBedrockHeader(in)
+	if err != nil {
+		return 0, err
+	}
+
+	dec := NewDecoder(io.LimitReader(in, int64(length)))
+	dec.SetByteOrder(binary.LittleEndian)
+	if err := dec.Decode(v); err != nil {
+		return version, err
+	}
+	if dec.More() {
+		return version, fmt.Errorf("nbt: level.dat has data after its root tag")
+	}
+	return version, nil
+}
+
+// Writes v as a Bedrock Edition level.dat file with the given storage version.
+func MarshalBedrockLevel(out io.Writer, version int32, v interface{}) error {
+	var data bytes.Buffer
+	enc := NewEncoder(&data)
+	enc.SetByteOrder(binary.LittleEndian)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+
+	if err := WriteBedrockHeader(out, version, uint32(data.Len())); err != nil {
+		return err
+	}
+	_, err := data.WriteTo(out)
+	return err
+}
@@ -0,0 +1,293 @@
+package nbt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestLittleEndian(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		compression Compression
+	}{
+		{"bigtest.nbt", GZip},
+		{"longarraytest.nbt", GZip},
+	} {
+		data := readTestcase(t, test.name, test.compression)
+
+		var root *Compound
+		if err := NewDecoder(bytes.NewReader(data)).Decode(&root); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		var little bytes.Buffer
+		enc := NewEncoder(&little)
+		enc.SetByteOrder(binary.LittleEndian)
+		enc.SetRootName(rootName(data))
+		if err := enc.Encode(root); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		enc.Flush()
+		if bytes.Equal(little.Bytes(), data) {
+			t.Errorf("%s: Little-endian encoding is the same as big-endian", test.name)
+		}
+
+		var decoded *Compound
+		dec := NewDecoder(&little)
+		dec.SetByteOrder(binary.LittleEndian)
+		if err := dec.Decode(&decoded); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		var big bytes.Buffer
+		enc = NewEncoder(&big)
+		enc.SetRootName(rootName(data))
+		if err := enc.Encode(decoded); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		enc.Flush()
+		if !bytes.Equal(big.Bytes(), data) {
+			t.Errorf("%s: Little-endian round trip differs from the original", test.name)
+		}
+	}
+}
+
+type BedrockLevel struct {
+	LevelName    string
+	RandomSeed   int64
+	SpawnX       int32
+	SpawnY       int32
+	SpawnZ       int32
+	Difficulty   int32
+	LastPlayed   int64
+	Experiments  map[string]int8 `nbt:"experiments"`
+	MinimumAPI   []int32         `nbt:"MinimumCompatibleClientVersion"`
+	CommandsFlag bool            `nbt:"commandsEnabled"`
+}
+
+func TestBedrockLevel(t *testing.T) {
+	level := BedrockLevel{
+		LevelName:    "Bedrock level",
+		RandomSeed:   -1234567890123,
+		SpawnX:       -4,
+		SpawnY:       32767,
+		SpawnZ:       16,
+		Difficulty:   2,
+		LastPlayed:   1700000000,
+		Experiments:  map[string]int8{"experiments_ever_used": 0},
+		MinimumAPI:   []int32{1, 20, 0, 1, 0},
+		CommandsFlag: true,
+	}
+
+	var file bytes.Buffer
+	if err := MarshalBedrockLevel(&file, 10, level); err != nil {
+		t.Fatal(err)
+	}
+
+	data := file.Bytes()
+	if version, length, err := ReadBedrockHeader(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	} else if version != 10 || int(length) != len(data)-8 {
+		t.Errorf("Header == version %d, length %d, but the file is %d bytes", version, length, len(data))
+	}
+	// The root compound with an empty name, then TAG_String "LevelName", all little-endian.
+	if expected := []byte{10, 0, 0, 8, 9, 0, 'L', 'e', 'v', 'e', 'l', 'N', 'a', 'm', 'e', 13, 0}; !bytes.HasPrefix(data[8:], expected) {
+		t.Errorf("Data starts with % x", data[8:8+len(expected)])
+	}
+
+	var decoded BedrockLevel
+	if version, err := UnmarshalBedrockLevel(bytes.NewReader(data), &decoded); err != nil {
+		t.Fatal(err)
+	} else if version != 10 {
+		t.Errorf("Version == %d", version)
+	}
+	if decoded.LevelName != level.LevelName || decoded.RandomSeed != level.RandomSeed || decoded.SpawnY != level.SpawnY ||
+		decoded.Experiments["experiments_ever_used"] != 0 || len(decoded.MinimumAPI) != 5 || decoded.MinimumAPI[1] != 20 || !decoded.CommandsFlag {
+		t.Errorf("Decoded %#v", decoded)
+	}
+
+	// Extra data after the root is an error, but data after the length in the header is not read.
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(data)-8+1))
+	if _, err := UnmarshalBedrockLevel(bytes.NewReader(append(data, 0)), &decoded); err == nil {
+		t.Errorf("Trailing data in level.dat was not reported")
+	}
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(data)-8))
+	if _, err := UnmarshalBedrockLevel(bytes.NewReader(append(data, 0)), &decoded); err != nil {
+		t.Errorf("Data after level.dat: %v", err)
+	}
+}
+
+type NetworkValues struct {
+	I int32 `nbt:"i"`
+	L int64 `nbt:"l"`
+	S string
+	A []int32 `nbt:"a,intarray"`
+	H int16   `nbt:"h"`
+}
+
+func TestNetworkFormat(t *testing.T) {
+	in := NetworkValues{I: -2, L: 300, S: "hi", A: []int32{1, -1}, H: 0x0102}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.UseNetworkFormat()
+	if err := enc.Encode(in); err != nil {
+		t.Fatal(err)
+	}
+	enc.Flush()
+
+	expected := []byte{
+		10, 0,
+		3, 1, 'i', 3, // zig-zag -2
+		4, 1, 'l', 0xd8, 0x04, // zig-zag 300
+		8, 1, 'S', 2, 'h', 'i',
+		11, 1, 'a', 4, 2, 1, // length 2, then 1 and -1
+		2, 1, 'h', 0x02, 0x01,
+		0,
+	}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("Encoded % x, but expected % x", buf.Bytes(), expected)
+	}
+
+	var out NetworkValues
+	dec := NewDecoder(bytes.NewReader(expected))
+	dec.UseNetworkFormat()
+	if err := dec.Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if out.I != in.I || out.L != in.L || out.S != in.S || len(out.A) != 2 || out.A[1] != -1 || out.H != in.H {
+		t.Errorf("Decoded %#v", out)
+	}
+
+	// Every tag can be skipped.
+	dec = NewDecoder(bytes.NewReader(expected))
+	dec.UseNetworkFormat()
+	if err := dec.Decode(&struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if dec.More() {
+		t.Errorf("Skipping left data behind")
+	}
+
+	// An int can't take more than five bytes.
+	dec = NewDecoder(bytes.NewReader([]byte{3, 0, 0xff, 0xff, 0xff, 0xff, 0x1f}))
+	dec.UseNetworkFormat()
+	var i int32
+	if err := dec.Decode(&i); err == nil {
+		t.Errorf("An overlong varint was decoded as %d", i)
+	}
+}
+
+func TestNetworkFormatLimits(t *testing.T) {
+	var v struct {
+		L []struct{ X int32 } `nbt:"l"`
+	}
+
+	// A list "l" of compounds with length -1 (zig-zag 1).
+	dec := NewDecoder(bytes.NewReader([]byte{10, 0, 9, 1, 'l', 10, 1}))
+	dec.UseNetworkFormat()
+	if err := dec.Decode(&v); err == nil {
+		t.Errorf("A negative length was decoded")
+	} else if _, ok := err.(*DecodeError); !ok {
+		t.Errorf("Error is a %T, but expected *DecodeError", err)
+	}
+
+	// The same list with a length of 0x7fffffff, which is more than the default limit.
+	huge := []byte{10, 0, 9, 1, 'l', 10, 0xfe, 0xff, 0xff, 0xff, 0x0f}
+	dec = NewDecoder(bytes.NewReader(huge))
+	dec.UseNetworkFormat()
+	if err := dec.Decode(&v); err == nil || err.(*DecodeError).Err == io.ErrUnexpectedEOF {
+		t.Errorf("Error is %v, but expected the length limit", err)
+	}
+
+	// Without the limit, the missing elements are still reported, without allocating them first.
+	dec = NewDecoder(bytes.NewReader(huge))
+	dec.SetMaxLength(0)
+	dec.UseNetworkFormat()
+	if err := dec.Decode(&v); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Error is %v, but expected io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestNetworkFormatRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		compression Compression
+	}{
+		{"bigtest.nbt", GZip},
+		{"longarraytest.nbt", GZip},
+		{"Nightgunner5.dat", GZip},
+	} {
+		data := readTestcase(t, test.name, test.compression)
+
+		var root *Compound
+		if err := NewDecoder(bytes.NewReader(data)).Decode(&root); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		var network bytes.Buffer
+		enc := NewEncoder(&network)
+		enc.UseNetworkFormat()
+		enc.SetRootName(rootName(data))
+		if err := enc.Encode(root); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		enc.Flush()
+
+		var decoded *Compound
+		dec := NewDecoder(bytes.NewReader(network.Bytes()))
+		dec.UseNetworkFormat()
+		if err := dec.Decode(&decoded); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		var big bytes.Buffer
+		enc = NewEncoder(&big)
+		enc.SetRootName(rootName(data))
+		if err := enc.Encode(decoded); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		enc.Flush()
+		if !bytes.Equal(big.Bytes(), data) {
+			t.Errorf("%s: Network format round trip differs from the original", test.name)
+		}
+	}
+}
+
+func TestNetworkFormatMarshaler(t *testing.T) {
+	in := Marshalers{
+		Owner:   UUID{0: 1, 15: 2},
+		Friends: []UUID{{3: 0xff}},
+		Home:    &BlockPos{-1, 64, 1},
+		Beds:    map[string]BlockPos{"nether": {0, -64, 0}},
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.UseNetworkFormat()
+	if err := enc.Encode(in); err != nil {
+		t.Fatal(err)
+	}
+	enc.Flush()
+
+	var out Marshalers
+	dec := NewDecoder(&buf)
+	dec.UseNetworkFormat()
+	if err := dec.Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Owner != in.Owner || len(out.Friends) != 1 || out.Friends[0] != in.Friends[0] || *out.Home != *in.Home || out.Beds["nether"] != in.Beds["nether"] {
+		t.Errorf("Decoded %#v", out)
+	}
+}
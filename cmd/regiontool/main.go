@@ -0,0 +1,87 @@
+// Command regiontool checks and compacts Minecraft region files.
+//
+//	regiontool verify r.0.0.mca...
+//	regiontool compact [-o out.mca] r.0.0.mca...
+//
+// verify lists the problems in each region file and how many of its sectors are dead. It exits
+// with status 1 if it finds any problem.
+//
+// compact rewrites each region file without its dead sectors and the chunks that can't be read.
+// The files are replaced in place unless -o is given, which only works with a single file. Chunks
+// too big for the region are written to .mcc files next to the output, named after the original
+// region's position.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/Nightgunner5/go.nbt/region"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: regiontool verify file...\n")
+	fmt.Fprintf(os.Stderr, "       regiontool compact [-o output] file...\n")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	flags := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	flags.Usage = usage
+	var output string
+	switch os.Args[1] {
+	case "verify":
+	case "compact":
+		flags.StringVar(&output, "o", "", "write the compacted region to this file instead of replacing the original")
+	default:
+		usage()
+	}
+	flags.Parse(os.Args[2:])
+	if flags.NArg() == 0 || (output != "" && flags.NArg() != 1) {
+		usage()
+	}
+
+	status := 0
+	for _, path := range flags.Args() {
+		r, err := region.Open(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+
+		problems := 0
+		report := func(p *region.Problem) {
+			problems++
+			fmt.Printf("%s: %v\n", path, p)
+		}
+
+		var rep region.Report
+		if os.Args[1] == "verify" {
+			rep, err = r.Verify(report)
+		} else {
+			dest := output
+			if dest == "" {
+				dest = path
+			}
+			rep, err = r.Compact(dest, report)
+		}
+		r.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			status = 1
+			continue
+		}
+
+		fmt.Printf("%s: %d of %d chunks good, %d of %d sectors dead\n", path, rep.Good, rep.Chunks, rep.Sectors-rep.UsedSectors, rep.Sectors)
+		if problems != 0 && os.Args[1] == "verify" {
+			status = 1
+		}
+	}
+	os.Exit(status)
+}
@@ -0,0 +1,223 @@
+package nbt
+
+import (
+	"reflect"
+	"sync"
+)
+
+// Everything that can be worked out from a Go type alone is worked out once per type and cached
+// here, so that encoding and decoding only have to look at values. The caches are safe for
+// concurrent use.
+var (
+	structInfoCache sync.Map // map[reflect.Type]*structInfo
+	decoderCache    sync.Map // map[reflect.Type]decoderFunc
+	encoderCache    sync.Map // map[reflect.Type]*encoder
+)
+
+// Returns the fields of a struct type.
+func getStructInfo(t reflect.Type) *structInfo {
+	if info, ok := structInfoCache.Load(t); ok {
+		return info.(*structInfo)
+	}
+	info, _ := structInfoCache.LoadOrStore(t, newStructInfo(t))
+	return info.(*structInfo)
+}
+
+// A decoderFunc reads the payload of a tag into v, which is settable and of the type the
+// function was made for.
+type decoderFunc func(d *decodeState, tag Tag, v reflect.Value)
+
+func decoderFor(t reflect.Type) decoderFunc {
+	if f, ok := decoderCache.Load(t); ok {
+		return f.(decoderFunc)
+	}
+	f, _ := decoderCache.LoadOrStore(t, newDecoder(t))
+	return f.(decoderFunc)
+}
+
+func newDecoder(t reflect.Type) decoderFunc {
+	if isTreeType(t) {
+		return (*decodeState).readTreeValue
+	}
+
+	decode := newKindDecoder(t)
+	if (t.Kind() == reflect.Ptr && t.Implements(unmarshalerType)) || (t.Kind() != reflect.Ptr && reflect.PtrTo(t).Implements(unmarshalerType)) {
+		return func(d *decodeState, tag Tag, v reflect.Value) {
+			if u, ok := asUnmarshaler(v); ok {
+				d.callUnmarshaler(tag, u)
+				return
+			}
+			decode(d, tag, v)
+		}
+	}
+	return decode
+}
+
+func newKindDecoder(t reflect.Type) decoderFunc {
+	switch t.Kind() {
+	case reflect.Int, reflect.Uint:
+		return func(d *decodeState, tag Tag, v reflect.Value) {
+			panic(d.errorf("nbt: int and uint types are not supported for portability reasons. Try int32 or uint32."))
+		}
+
+	case reflect.Interface:
+		return (*decodeState).readInterface
+
+	case reflect.Ptr:
+		elem := t.Elem()
+		return func(d *decodeState, tag Tag, v reflect.Value) {
+			v.Set(reflect.New(elem))
+			d.readValue(tag, v.Elem())
+		}
+
+	case reflect.Bool, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		exact := tagFor(t)
+		return func(d *decodeState, tag Tag, v reflect.Value) {
+			if tag != exact {
+				d.mismatch(tag, v)
+				return
+			}
+			value := d.readInt(tag)
+			switch v.Kind() {
+			case reflect.Bool:
+				v.SetBool(value != 0)
+			case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				v.SetInt(value)
+			default:
+				// Negative values wrap around, so a TAG_Byte of -1 is a uint8 of 255.
+				v.SetUint(uint64(value))
+			}
+		}
+
+	case reflect.Float32, reflect.Float64:
+		exact := tagFor(t)
+		return func(d *decodeState, tag Tag, v reflect.Value) {
+			if tag != exact {
+				d.mismatch(tag, v)
+				return
+			}
+			v.SetFloat(d.readFloat(tag))
+		}
+
+	case reflect.String:
+		return (*decodeState).readStringValue
+
+	case reflect.Array, reflect.Slice:
+		return (*decodeState).readSequence
+
+	case reflect.Struct:
+		return (*decodeState).readStruct
+
+	case reflect.Map:
+		return (*decodeState).readMap
+	}
+	return (*decodeState).mismatch
+}
+
+// Reports whether the elements of an array or slice type are bytes without an UnmarshalNBT
+// method, so they can be read in one piece.
+func isPlainBytes(t reflect.Type) bool {
+	elem := t.Elem()
+	return elem.Kind() == reflect.Uint8 && !reflect.PtrTo(elem).Implements(unmarshalerType)
+}
+
+// An encoder writes values of one Go type.
+type encoder struct {
+	tag       Tag  // The tag the type is written as, or TAG_End if it can't be encoded.
+	marshaler bool // Whether the type is a Marshaler, which decides the tag itself.
+
+	// Writes the payload of v. If the type can't be encoded, it reports the error instead.
+	encode func(e *encodeState, v reflect.Value)
+}
+
+func encoderFor(t reflect.Type) *encoder {
+	if c, ok := encoderCache.Load(t); ok {
+		return c.(*encoder)
+	}
+	c, _ := encoderCache.LoadOrStore(t, newEncoder(t))
+	return c.(*encoder)
+}
+
+func newEncoder(t reflect.Type) *encoder {
+	if t.Kind() != reflect.Interface && isTreeType(t) {
+		var value Value
+		if t.Implements(valueType) {
+			value = reflect.Zero(t).Interface().(Value)
+		} else {
+			value = reflect.New(t).Interface().(Value)
+		}
+		return &encoder{tag: value.Tag(), encode: func(e *encodeState, v reflect.Value) {
+			value, _ := treeValue(v)
+			e.writeTree(value)
+		}}
+	}
+	if t.Implements(marshalerType) || reflect.PtrTo(t).Implements(marshalerType) {
+		return &encoder{marshaler: true}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &encoder{tag: TAG_Byte, encode: func(e *encodeState, v reflect.Value) {
+			if v.Bool() {
+				e.writeByte(1)
+			} else {
+				e.writeByte(0)
+			}
+		}}
+
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		tag := tagFor(t)
+		return &encoder{tag: tag, encode: func(e *encodeState, v reflect.Value) {
+			e.writeInt(tag, uint64(v.Int()))
+		}}
+
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		tag := tagFor(t)
+		return &encoder{tag: tag, encode: func(e *encodeState, v reflect.Value) {
+			e.writeInt(tag, v.Uint())
+		}}
+
+	case reflect.Float32, reflect.Float64:
+		tag := tagFor(t)
+		return &encoder{tag: tag, encode: func(e *encodeState, v reflect.Value) {
+			e.writeFloat(tag, v.Float())
+		}}
+
+	case reflect.String:
+		return &encoder{tag: TAG_String, encode: func(e *encodeState, v reflect.Value) {
+			e.writeString(v.String())
+		}}
+
+	case reflect.Array:
+		tag := tagFor(t)
+		if tag == TAG_End {
+			return &encoder{encode: func(e *encodeState, v reflect.Value) {
+				panic(unhandledType(v.Type(), "nbt: Unhandled array type: %v", v.Type().Elem()))
+			}}
+		}
+		return &encoder{tag: tag, encode: (*encodeState).writeArray}
+
+	case reflect.Slice:
+		return &encoder{tag: TAG_List, encode: (*encodeState).writeList}
+
+	case reflect.Map:
+		return &encoder{tag: TAG_Compound, encode: (*encodeState).writeMap}
+
+	case reflect.Struct:
+		return &encoder{tag: TAG_Compound, encode: (*encodeState).writeCompound}
+
+	case reflect.Ptr:
+		elem := encoderFor(t.Elem())
+		return &encoder{tag: elem.tag, marshaler: elem.marshaler, encode: func(e *encodeState, v reflect.Value) {
+			if v.IsNil() {
+				panic(unhandledType(v.Type(), "nbt: Unhandled nil value"))
+			}
+			elem.encode(e, v.Elem())
+		}}
+	}
+
+	return &encoder{encode: func(e *encodeState, v reflect.Value) {
+		panic(unhandledType(v.Type(), "nbt: Unhandled type: %v (%v)", v.Type(), v.Interface()))
+	}}
+}
@@ -0,0 +1,115 @@
+package nbt
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func benchmarkUnmarshal(b *testing.B, name string, newValue func() interface{}) {
+	data := readTestcase(b, name, GZip)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := Unmarshal(Uncompressed, bytes.NewReader(data), newValue()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkMarshal(b *testing.B, name string, v interface{}) {
+	data := readTestcase(b, name, GZip)
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), v); err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := Marshal(Uncompressed, ioutil.Discard, v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalBigTest(b *testing.B) {
+	benchmarkUnmarshal(b, "bigtest.nbt", func() interface{} { return new(BigTest) })
+}
+
+func BenchmarkMarshalBigTest(b *testing.B) {
+	benchmarkMarshal(b, "bigtest.nbt", new(BigTest))
+}
+
+func BenchmarkUnmarshalPlayer(b *testing.B) {
+	benchmarkUnmarshal(b, "Nightgunner5.dat", func() interface{} { return new(Player) })
+}
+
+func BenchmarkMarshalPlayer(b *testing.B) {
+	benchmarkMarshal(b, "Nightgunner5.dat", new(Player))
+}
+
+func BenchmarkUnmarshalTree(b *testing.B) {
+	benchmarkUnmarshal(b, "bigtest.nbt", func() interface{} { return new(*Compound) })
+}
+
+func BenchmarkMarshalTree(b *testing.B) {
+	benchmarkMarshal(b, "bigtest.nbt", new(*Compound))
+}
+
+func TestCodecConcurrent(t *testing.T) {
+	data := readTestcase(t, "bigtest.nbt", GZip)
+
+	type result struct {
+		encoded []byte
+		err     error
+	}
+	results := make(chan result, 8)
+	for i := 0; i < 8; i++ {
+		go func() {
+			var v BigTest
+			if err := Unmarshal(Uncompressed, bytes.NewReader(data), &v); err != nil {
+				results <- result{err: err}
+				return
+			}
+			var encoded bytes.Buffer
+			err := Marshal(Uncompressed, &encoded, v)
+			results <- result{encoded.Bytes(), err}
+		}()
+	}
+
+	var first []byte
+	for i := 0; i < 8; i++ {
+		r := <-results
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		if first == nil {
+			first = r.encoded
+		} else if !bytes.Equal(first, r.encoded) {
+			t.Error("Concurrent calls encoded different bytes")
+		}
+	}
+}
+
+func TestMarshalAllocs(t *testing.T) {
+	var v struct {
+		BigTest
+		Rest map[string]interface{} `nbt:",rest"`
+	}
+	data := readTestcase(t, "bigtest.nbt", GZip)
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &v.BigTest); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the encodeState itself is allocated, not anything per struct.
+	if allocs := testing.AllocsPerRun(100, func() {
+		if err := Marshal(Uncompressed, ioutil.Discard, &v); err != nil {
+			t.Fatal(err)
+		}
+	}); allocs > 1 {
+		t.Errorf("Marshal made %v allocations, but expected 1", allocs)
+	}
+}
@@ -0,0 +1,167 @@
+package nbt
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Compression levels for gzip, zlib and raw DEFLATE, as in compress/flate. Other compression
+// types may ignore them.
+const (
+	NoCompression      = flate.NoCompression
+	BestSpeed          = flate.BestSpeed
+	BestCompression    = flate.BestCompression
+	DefaultCompression = flate.DefaultCompression
+)
+
+type compressor interface {
+	io.Writer
+	Flush() error
+	Close() error
+}
+
+type compressionFuncs struct {
+	newReader func(in io.Reader) (io.Reader, error)
+	newWriter func(out io.Writer, level int) (io.WriteCloser, error)
+}
+
+var (
+	compressionsMu sync.RWMutex
+	compressions   = make(map[Compression]compressionFuncs)
+)
+
+// Makes a compression type available to Unmarshal, Marshal, Debug, Decoder and Encoder. It
+// replaces any earlier registration of id, including the built-in ones. newWriter may be nil if
+// the compression type is only used for reading. If the writer it returns has a Flush() error
+// method, Encoder.Flush calls it.
+func RegisterCompression(id Compression, newReader func(in io.Reader) (io.Reader, error), newWriter func(out io.Writer, level int) (io.WriteCloser, error)) {
+	if id == Uncompressed || id == AutoDetect {
+		panic(fmt.Errorf("nbt: Compression type %d can't be registered", id))
+	}
+	if newReader == nil {
+		panic(fmt.Errorf("nbt: RegisterCompression needs a reader for compression type %d", id))
+	}
+
+	compressionsMu.Lock()
+	defer compressionsMu.Unlock()
+	compressions[id] = compressionFuncs{newReader, newWriter}
+}
+
+func init() {
+	RegisterCompression(GZip, func(in io.Reader) (io.Reader, error) {
+		return gzip.NewReader(in)
+	}, func(out io.Writer, level int) (io.WriteCloser, error) {
+		return gzip.NewWriterLevel(out, level)
+	})
+	RegisterCompression(ZLib, func(in io.Reader) (io.Reader, error) {
+		return zlib.NewReader(in)
+	}, func(out io.Writer, level int) (io.WriteCloser, error) {
+		return zlib.NewWriterLevel(out, level)
+	})
+	RegisterCompression(Deflate, func(in io.Reader) (io.Reader, error) {
+		return flate.NewReader(in), nil
+	}, func(out io.Writer, level int) (io.WriteCloser, error) {
+		return flate.NewWriter(out, level)
+	})
+	RegisterCompression(LZ4, func(in io.Reader) (io.Reader, error) {
+		return newLZ4Reader(in), nil
+	}, func(out io.Writer, level int) (io.WriteCloser, error) {
+		return newLZ4Writer(out), nil
+	})
+}
+
+func lookupCompression(compression Compression) compressionFuncs {
+	compressionsMu.RLock()
+	defer compressionsMu.RUnlock()
+
+	funcs, ok := compressions[compression]
+	if !ok {
+		panic(fmt.Errorf("nbt: Unknown compression type: %d", compression))
+	}
+	return funcs
+}
+
+// Returns a reader for the decompressed contents of in.
+func decompress(compression Compression, in io.Reader) io.Reader {
+	if in == nil {
+		panic(fmt.Errorf("nbt: Input stream is nil"))
+	}
+
+	if compression == AutoDetect {
+		buffered, ok := in.(*bufio.Reader)
+		if !ok {
+			buffered = bufio.NewReader(in)
+			in = buffered
+		}
+		compression = detectCompression(buffered)
+	}
+	if compression == Uncompressed {
+		return in
+	}
+
+	r, err := lookupCompression(compression).newReader(in)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Returns nil if the compression type does not need a compressor.
+func compress(compression Compression, level int, out io.Writer) compressor {
+	if compression == Uncompressed {
+		return nil
+	}
+
+	newWriter := lookupCompression(compression).newWriter
+	if newWriter == nil {
+		panic(fmt.Errorf("nbt: Compression type %d can only be decoded", compression))
+	}
+	w, err := newWriter(out, level)
+	if err != nil {
+		panic(err)
+	}
+	if c, ok := w.(compressor); ok {
+		return c
+	}
+	return nopFlusher{w}
+}
+
+type nopFlusher struct {
+	io.WriteCloser
+}
+
+func (nopFlusher) Flush() error { return nil }
+
+// Guesses the compression of a stream from its first bytes without consuming them. Raw DEFLATE
+// streams have no header, so they are never detected.
+func detectCompression(in *bufio.Reader) Compression {
+	magic, err := in.Peek(len(lz4Magic))
+	if len(magic) == 0 {
+		// Let the decoder report the empty input.
+		return Uncompressed
+	}
+	if err != nil && err != io.EOF {
+		panic(err)
+	}
+
+	switch {
+	case len(magic) >= 2 && magic[0] == 0x1f && magic[1] == 0x8b:
+		return GZip
+	case len(magic) >= 2 && magic[0] == 0x78 && (uint(magic[0])<<8|uint(magic[1]))%31 == 0:
+		return ZLib
+	case bytes.Equal(magic, lz4Magic):
+		return LZ4
+	case magic[0] <= byte(TAG_Long_Array):
+		return Uncompressed
+	}
+	if len(magic) > 2 {
+		magic = magic[:2]
+	}
+	panic(fmt.Errorf("nbt: Unable to detect the compression of the input, which starts with 0x%x", magic))
+}
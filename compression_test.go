@@ -0,0 +1,160 @@
+package nbt
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompressionRoundTrip(t *testing.T) {
+	data := readTestcase(t, "bigtest.nbt", GZip)
+
+	var expected *Compound
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &expected); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, compression := range []Compression{Uncompressed, GZip, ZLib, Deflate, LZ4} {
+		for _, level := range []int{BestSpeed, DefaultCompression, BestCompression} {
+			var encoded bytes.Buffer
+			if err := MarshalLevel(compression, level, &encoded, expected); err != nil {
+				t.Errorf("%d (level %d): %v", compression, level, err)
+				continue
+			}
+
+			var result *Compound
+			if err := Unmarshal(compression, bytes.NewReader(encoded.Bytes()), &result); err != nil {
+				t.Errorf("%d (level %d): %v", compression, level, err)
+			} else if !reflect.DeepEqual(result, expected) {
+				t.Errorf("%d (level %d): Decoded something else", compression, level)
+			}
+
+			if compression == Deflate {
+				continue
+			}
+			if err := Unmarshal(AutoDetect, bytes.NewReader(encoded.Bytes()), &result); err != nil {
+				t.Errorf("%d (level %d) detected: %v", compression, level, err)
+			}
+		}
+	}
+}
+
+func TestLZ4(t *testing.T) {
+	if sum := xxh32([]byte("abc"), 0); sum != 0x32d153ff {
+		t.Errorf("xxh32(abc) == %#x, but expected 0x32d153ff", sum)
+	}
+
+	// Several blocks, with both compressible and random data.
+	random := rand.New(rand.NewSource(1))
+	var input []byte
+	for len(input) < 3*lz4BlockSize {
+		if random.Intn(2) == 0 {
+			input = append(input, strings.Repeat("minecraft:stone ", random.Intn(100))...)
+		} else {
+			for i := random.Intn(1000); i > 0; i-- {
+				input = append(input, byte(random.Intn(256)))
+			}
+		}
+	}
+
+	var compressed bytes.Buffer
+	w := newLZ4Writer(&compressed)
+	if _, err := w.Write(input); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if compressed.Len() >= len(input) {
+		t.Errorf("Compressed %d bytes into %d", len(input), compressed.Len())
+	}
+
+	output, err := ioutil.ReadAll(newLZ4Reader(bytes.NewReader(compressed.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(output, input) {
+		t.Error("Decompressed data differs from the input")
+	}
+
+	corrupt := append([]byte(nil), compressed.Bytes()...)
+	corrupt[lz4HeaderSize+100] ^= 0xff
+	if _, err := ioutil.ReadAll(newLZ4Reader(bytes.NewReader(corrupt))); err == nil {
+		t.Error("No error for corrupt data")
+	}
+}
+
+// bigtest.lz4 is bigtest.nbt in the format of lz4-java's LZ4BlockOutputStream with its default
+// settings, as Minecraft writes chunks: a block compressed by the reference LZ4 library (1.9.4),
+// whose output differs from this package's compressor, followed by the empty end block.
+func TestLZ4Compatibility(t *testing.T) {
+	f, err := os.Open("testcases/bigtest.lz4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	output, err := ioutil.ReadAll(newLZ4Reader(f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(output, readTestcase(t, "bigtest.nbt", GZip)) {
+		t.Error("Decompressed data differs from bigtest.nbt")
+	}
+}
+
+type rot13 struct {
+	r io.Reader
+}
+
+func (r rot13) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	for i := range p[:n] {
+		p[i] += 13
+	}
+	return n, err
+}
+
+type rot13Writer struct {
+	w io.Writer
+}
+
+func (w rot13Writer) Write(p []byte) (int, error) {
+	q := make([]byte, len(p))
+	for i := range p {
+		q[i] = p[i] - 13
+	}
+	return w.w.Write(q)
+}
+
+func (w rot13Writer) Close() error { return nil }
+
+func TestRegisterCompression(t *testing.T) {
+	const Rot13 Compression = 100
+	RegisterCompression(Rot13, func(in io.Reader) (io.Reader, error) {
+		return rot13{in}, nil
+	}, func(out io.Writer, level int) (io.WriteCloser, error) {
+		return rot13Writer{out}, nil
+	})
+
+	var encoded bytes.Buffer
+	if err := Marshal(Rot13, &encoded, map[string]string{"name": "Bananrama"}); err != nil {
+		t.Fatal(err)
+	}
+	var result map[string]string
+	if err := Unmarshal(Rot13, &encoded, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result["name"] != "Bananrama" {
+		t.Errorf("Decoded %v", result)
+	}
+
+	if err := Marshal(Compression(101), ioutil.Discard, result); err == nil || err.Error() != "nbt: Unknown compression type: 101" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -1,8 +1,6 @@
 package nbt
 
 import (
-	"compress/gzip"
-	"compress/zlib"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -10,38 +8,22 @@ import (
 
 // Prints a human-readable representation of an NBT file to stdout.
 func Debug(compression Compression, in io.Reader) {
-	new(debugState).init(compression, in).debug(0)
-	return
+	DebugByteOrder(compression, binary.BigEndian, in)
+}
+
+// Like Debug, but for files in another byte order, such as binary.LittleEndian for Bedrock
+// Edition.
+func DebugByteOrder(compression Compression, order binary.ByteOrder, in io.Reader) {
+	(&debugState{order: order}).init(compression, in).debug(0)
 }
 
 type debugState struct {
-	in io.Reader
+	in    io.Reader
+	order binary.ByteOrder
 }
 
 func (d *debugState) init(compression Compression, in io.Reader) *debugState {
-	if in == nil {
-		panic(fmt.Errorf("nbt: Input stream is nil"))
-	}
-
-	switch compression {
-	case Uncompressed:
-		d.in = in
-	case GZip:
-		r, err := gzip.NewReader(in)
-		if err != nil {
-			panic(err)
-		}
-		d.in = r
-	case ZLib:
-		r, err := zlib.NewReader(in)
-		if err != nil {
-			panic(err)
-		}
-		d.in = r
-	default:
-		panic(fmt.Errorf("nbt: Unknown compression type: %d", compression))
-	}
-
+	d.in = decompress(compression, in)
 	return d
 }
 
@@ -61,7 +43,7 @@ func (d *debugState) debug(indent int) bool {
 }
 
 func (d *debugState) r(i interface{}) {
-	err := binary.Read(d.in, binary.BigEndian, i)
+	err := binary.Read(d.in, d.order, i)
 	if err != nil {
 		panic(err)
 	}
@@ -171,6 +153,16 @@ func (d *debugState) debugValue(indent int, tag Tag) {
 		}
 		d.printf(indent, "}")
 
+	case TAG_Long_Array:
+		var length uint32
+		d.r(&length)
+		d.printf(indent, "Length: %d", length)
+		d.printf(indent, "Values: {")
+		for i := uint32(0); i < length; i++ {
+			d.debugValue(indent + 1, TAG_Long)
+		}
+		d.printf(indent, "}")
+
 	default:
 		panic(fmt.Errorf("nbt: Unhandled tag: %s", tag))
 	}
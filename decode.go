@@ -1,75 +1,243 @@
 package nbt
 
 import (
-	"compress/gzip"
-	"compress/zlib"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
 	"reflect"
 )
 
-func Unmarshal(compression Compression, in io.Reader, v interface{}) (err error) {
+// Reads a single root tag from in and stores it in the value pointed to by v. Errors are of type
+// *DecodeError.
+//
+// Unmarshal reports an error for compound entries that have no matching struct field. Use a
+// Decoder to skip them instead.
+func Unmarshal(compression Compression, in io.Reader, v interface{}) error {
+	_, err := UnmarshalNamed(compression, in, v)
+	return err
+}
+
+// Like Unmarshal, but also returns the name of the root tag. If v points to a struct with a
+// field tagged `nbt:",rootname"`, the name is stored there too.
+func UnmarshalNamed(compression Compression, in io.Reader, v interface{}) (name string, err error) {
+	d := &decodeState{order: binary.BigEndian, disallowUnknownFields: true}
 	defer func() {
 		if r := recover(); r != nil {
-			if s, ok := r.(string); ok {
-				err = fmt.Errorf(s)
-			} else {
-				err = r.(error)
-			}
+			err = d.error(r)
 		}
 	}()
-	new(decodeState).init(compression, in).unmarshal(v)
+	name = d.init(compression, in).unmarshal(v)
 	return
 }
 
 type decodeState struct {
-	in io.Reader
+	in    io.Reader
+	order binary.ByteOrder
+
+	varint bool // Ints, longs and lengths are varints, as in Bedrock's network protocol.
+
+	nameless bool // The root tag has no name, as in Java's network protocol.
+
+	maxDepth  int  // Maximum nesting of lists and compounds, or 0 for no limit.
+	maxLength int  // Maximum number of elements in a list or array, or 0 for no limit.
+	convert   bool // Allow numeric tags to be stored in Go types of a different size.
+
+	disallowUnknownFields bool
+
+	depth  int
+	offset int64
+
+	buf [8]byte // Scratch space for numbers, so reading them doesn't allocate.
+	str []byte  // Scratch space for strings.
 }
 
 func (d *decodeState) init(compression Compression, in io.Reader) *decodeState {
-	if in == nil {
-		panic(fmt.Errorf("nbt: Input stream is nil"))
+	d.in = decompress(compression, in)
+	return d
+}
+
+// Reads a root tag into the value pointed to by v and returns its name.
+func (d *decodeState) unmarshal(v interface{}) string {
+	rv := reflect.ValueOf(v).Elem()
+	if !d.nameless {
+		name, tag := d.readTag()
+		d.readValue(tag, rv)
+		setRootName(rv, name)
+		return name
+	}
+
+	// A nameless root may be a lone TAG_End, which means the value is absent.
+	tag := Tag(d.readByte())
+	if tag == TAG_End {
+		rv.Set(reflect.Zero(rv.Type()))
+		return ""
 	}
+	d.readValue(tag, rv)
+	return ""
+}
+
+// Reads exactly len(p) bytes.
+func (d *decodeState) read(p []byte) {
+	n, err := io.ReadFull(d.in, p)
+	d.offset += int64(n)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (d *decodeState) readByte() byte {
+	d.read(d.buf[:1])
+	return d.buf[0]
+}
+
+func (d *decodeState) readUint16() uint16 {
+	d.read(d.buf[:2])
+	return d.order.Uint16(d.buf[:2])
+}
 
-	switch compression {
-	case Uncompressed:
-		d.in = in
-	case GZip:
-		r, err := gzip.NewReader(in)
-		if err != nil {
-			panic(err)
+func (d *decodeState) readUint32() uint32 {
+	d.read(d.buf[:4])
+	return d.order.Uint32(d.buf[:4])
+}
+
+func (d *decodeState) readUint64() uint64 {
+	d.read(d.buf[:8])
+	return d.order.Uint64(d.buf[:8])
+}
+
+// Reads an unsigned varint that fits in the given number of bits: seven bits to a byte, lowest
+// first, with the top bit set on every byte but the last.
+func (d *decodeState) readUvarint(bits uint) uint64 {
+	var u uint64
+	for shift := uint(0); shift < bits; shift += 7 {
+		b := d.readByte()
+		if bits-shift < 7 && b>>(bits-shift) != 0 {
+			break
 		}
-		d.in = r
-	case ZLib:
-		r, err := zlib.NewReader(in)
-		if err != nil {
-			panic(err)
+		u |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return u
 		}
-		d.in = r
-	default:
-		panic(fmt.Errorf("nbt: Unknown compression type: %d", compression))
 	}
+	panic(d.errorf("nbt: Varint does not fit in %d bits", bits))
+}
 
-	return d
+// Reads a zig-zag encoded varint, which stores small negative numbers in few bytes too.
+func (d *decodeState) readVarint(bits uint) int64 {
+	u := d.readUvarint(bits)
+	return int64(u>>1) ^ -int64(u&1)
 }
 
-func (d *decodeState) unmarshal(v interface{}) {
-	_, tag := d.readTag()
-	d.readValue(tag, reflect.ValueOf(v).Elem())
+// Reads the payload of an integer tag.
+func (d *decodeState) readInt(tag Tag) int64 {
+	switch tag {
+	case TAG_Byte:
+		return int64(int8(d.readByte()))
+	case TAG_Short:
+		return int64(int16(d.readUint16()))
+	case TAG_Int:
+		if d.varint {
+			return d.readVarint(32)
+		}
+		return int64(int32(d.readUint32()))
+	case TAG_Long:
+		if d.varint {
+			return d.readVarint(64)
+		}
+		return int64(d.readUint64())
+	}
+	panic(d.errorf("nbt: Unhandled tag: %s", tag))
 }
 
-func (d *decodeState) r(i interface{}) {
-	err := binary.Read(d.in, binary.BigEndian, i)
-	if err != nil {
-		panic(err)
+// Reads the length of an array or list, which is a signed int.
+func (d *decodeState) readLength() uint32 {
+	var length int64
+	if d.varint {
+		length = d.readVarint(32)
+	} else {
+		length = int64(int32(d.readUint32()))
+	}
+	if length < 0 {
+		panic(d.errorf("nbt: Negative length %d", length))
+	}
+	return uint32(length)
+}
+
+// The most elements allocated for an array or list before any of them are read. Longer ones grow
+// as they are read, so a bad length in truncated or hostile input runs into the end of the input
+// instead of allocating all of it up front.
+const maxPrealloc = 1024
+
+// Returns the number of elements to allocate for an array or list before reading it.
+func preallocLength(length uint32) int {
+	if length > maxPrealloc {
+		return maxPrealloc
+	}
+	return int(length)
+}
+
+// Reads n bytes into a new slice, growing it as it is read.
+func (d *decodeState) readBytes(n uint32) []byte {
+	b := make([]byte, preallocLength(n))
+	d.read(b)
+	for uint32(len(b)) < n {
+		start := len(b)
+		end := 2 * start
+		if uint32(end) > n {
+			end = int(n)
+		}
+		b = append(b, make([]byte, end-start)...)
+		d.read(b[start:])
+	}
+	return b
+}
+
+// Makes sure element i of a slice being read exists, doubling the slice as needed up to length
+// elements.
+func growSlice(v reflect.Value, i, length int) {
+	if i < v.Len() {
+		return
+	}
+	n := 2 * v.Len()
+	if n < maxPrealloc {
+		n = maxPrealloc
+	}
+	if n > length {
+		n = length
+	}
+	s := reflect.MakeSlice(v.Type(), n, n)
+	reflect.Copy(s, v)
+	v.Set(s)
+}
+
+// Reads length bytes into a byte slice or array, growing a slice as with growSlice.
+func (d *decodeState) readPlainBytes(v reflect.Value, length int) {
+	for i := 0; i < length; {
+		growSlice(v, i, length)
+		n := v.Len()
+		if n > length {
+			n = length
+		}
+		d.read(v.Slice(i, n).Bytes())
+		i = n
+	}
+}
+
+// Reads the payload of a floating point tag.
+func (d *decodeState) readFloat(tag Tag) float64 {
+	switch tag {
+	case TAG_Float:
+		return float64(math.Float32frombits(d.readUint32()))
+	case TAG_Double:
+		return math.Float64frombits(d.readUint64())
 	}
+	panic(d.errorf("nbt: Unhandled tag: %s", tag))
 }
 
 // Returns the name of the tag that was read.
 func (d *decodeState) readTag() (string, Tag) {
-	var tag Tag
-	d.r(&tag)
+	tag := Tag(d.readByte())
 
 	if tag == TAG_End {
 		return "", tag
@@ -104,259 +272,348 @@ func (d *decodeState) allocate(tag Tag) reflect.Value {
 		return reflect.ValueOf(new(map[string]interface{})).Elem()
 	case TAG_Int_Array:
 		return reflect.ValueOf(new([]int32)).Elem()
+	case TAG_Long_Array:
+		return reflect.ValueOf(new([]int64)).Elem()
 	}
-	panic(fmt.Errorf("nbt: Unhandled tag %s", tag))
+	panic(d.errorf("nbt: Unhandled tag %s", tag))
 }
 
 func (d *decodeState) readString() string {
-	var length uint16
-	d.r(&length)
+	length := d.readStringLength()
+	if length > math.MaxUint16 {
+		// Only varint lengths can be this long; don't trust them any more than array lengths.
+		return string(d.readBytes(uint32(length)))
+	}
+	if cap(d.str) < length {
+		d.str = make([]byte, length)
+	}
+	d.read(d.str[:length])
+	return string(d.str[:length])
+}
 
-	value := make([]byte, length)
-	_, err := d.in.Read(value)
-	if err != nil {
-		panic(err)
+func (d *decodeState) readStringLength() int {
+	if d.varint {
+		length := uint32(d.readUvarint(32))
+		d.checkLength(TAG_String, length)
+		return int(length)
 	}
+	return int(d.readUint16())
+}
 
-	return string(value)
+func (d *decodeState) checkLength(tag Tag, length uint32) {
+	if d.maxLength > 0 && length > uint32(d.maxLength) {
+		panic(d.errorf("nbt: %s is of length %d, which exceeds the limit of %d", tag, length, d.maxLength))
+	}
 }
 
-func (d *decodeState) readValue(tag Tag, v reflect.Value) {
-	switch v.Kind() {
-	case reflect.Int, reflect.Uint:
-		panic(fmt.Errorf("nbt: int and uint types are not supported for portability reasons. Try int32 or uint32."))
-	case reflect.Interface:
-		v.Set(d.allocate(tag))
-		v = v.Elem()
-	case reflect.Ptr:
-		v.Set(reflect.New(v.Type().Elem()))
-		v = v.Elem()
+func (d *decodeState) enter() {
+	d.depth++
+	if d.maxDepth > 0 && d.depth > d.maxDepth {
+		panic(d.errorf("nbt: Tags are nested more than %d deep", d.maxDepth))
 	}
+}
 
-	switch tag {
-	case TAG_Byte:
-		var value uint8
-		d.r(&value)
+func (d *decodeState) leave() {
+	d.depth--
+}
+
+// Stores an integer read from a tag of a different size in v, if conversion is allowed and the value fits.
+// As with a TAG_Byte in a uint8, negative values are treated as unsigned numbers of the tag's size when v
+// is unsigned.
+func (d *decodeState) convertInt(tag Tag, v reflect.Value, value int64) {
+	if d.convert {
 		switch v.Kind() {
+		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if !v.OverflowInt(value) {
+				v.SetInt(value)
+				return
+			}
+			panic(d.errorf("nbt: Value %d of %s overflows a %s", value, tag, v.Kind()))
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			unsigned := uint64(value)
+			if size := fixedSize(tag); value < 0 && size < 8 {
+				unsigned &= 1<<uint(size*8) - 1
+			}
+			if !v.OverflowUint(unsigned) {
+				v.SetUint(unsigned)
+				return
+			}
+			panic(d.errorf("nbt: Value %d of %s overflows a %s", value, tag, v.Kind()))
+		case reflect.Float32, reflect.Float64:
+			v.SetFloat(float64(value))
+			return
 		case reflect.Bool:
 			v.SetBool(value != 0)
-		case reflect.Int8:
-			v.SetInt(int64(int8(value)))
-		case reflect.Uint8:
-			v.SetUint(uint64(value))
-		default:
-			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
+			return
 		}
+	}
+	panic(d.typeError(tag, v))
+}
 
-	case TAG_Short:
-		var value uint16
-		d.r(&value)
+// Stores a floating point number read from a tag of a different size in v, if conversion is allowed.
+// Integer types only accept whole numbers that fit.
+func (d *decodeState) convertFloat(tag Tag, v reflect.Value, value float64) {
+	if d.convert {
 		switch v.Kind() {
-		case reflect.Int16:
-			v.SetInt(int64(int16(value)))
-		case reflect.Uint16:
-			v.SetUint(uint64(value))
-		default:
-			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
+		case reflect.Float32, reflect.Float64:
+			v.SetFloat(value)
+			return
+		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if value == math.Trunc(value) && value >= -1<<63 && value < 1<<63 && !v.OverflowInt(int64(value)) {
+				v.SetInt(int64(value))
+				return
+			}
+			panic(d.errorf("nbt: Value %v of %s does not fit in a %s", value, tag, v.Kind()))
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if value == math.Trunc(value) && value >= 0 && value < 1<<64 && !v.OverflowUint(uint64(value)) {
+				v.SetUint(uint64(value))
+				return
+			}
+			panic(d.errorf("nbt: Value %v of %s does not fit in a %s", value, tag, v.Kind()))
 		}
+	}
+	panic(d.typeError(tag, v))
+}
 
-	case TAG_Int:
-		var value uint32
-		d.r(&value)
-		switch v.Kind() {
-		case reflect.Int32:
-			v.SetInt(int64(int16(value)))
-		case reflect.Uint32:
-			v.SetUint(uint64(value))
-		default:
-			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
-		}
+func (d *decodeState) readField(tag Tag, v reflect.Value, f structField) {
+	if !f.convert || d.convert {
+		d.readValue(tag, fieldByIndex(v, f.index))
+		return
+	}
 
-	case TAG_Long:
-		var value uint64
-		d.r(&value)
-		switch v.Kind() {
-		case reflect.Int64:
-			v.SetInt(int64(value))
-		case reflect.Uint64:
-			v.SetUint(value)
-		default:
-			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
-		}
+	// Fields with an explicit tag type are stored in Go types that don't match it. Only numbers
+	// are converted: the field itself, or the elements of a slice or array of numbers.
+	d.convert = true
+	defer func() {
+		d.convert = false
+	}()
+	d.readValue(tag, fieldByIndex(v, f.index))
+}
 
-	case TAG_Float:
-		var value float32
-		d.r(&value)
-		switch v.Kind() {
-		case reflect.Float32:
-			v.SetFloat(float64(value))
-		default:
-			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
+// Stores a compound entry without a struct field of its own in the "rest" field, which is a map
+// or a *Compound.
+func (d *decodeState) readRest(rest reflect.Value, name string, tag Tag) {
+	switch rest.Type() {
+	case compoundType:
+		rest.Addr().Interface().(*Compound).Set(name, d.readTree(tag))
+		return
+	case reflect.PtrTo(compoundType):
+		if rest.IsNil() {
+			rest.Set(reflect.New(compoundType))
 		}
+		rest.Interface().(*Compound).Set(name, d.readTree(tag))
+		return
+	}
 
-	case TAG_Double:
-		var value float64
-		d.r(&value)
-		switch v.Kind() {
-		case reflect.Float64:
-			v.SetFloat(value)
-		default:
-			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
-		}
+	if rest.Kind() != reflect.Map || rest.Type().Key().Kind() != reflect.String {
+		panic(d.errorf("nbt: The rest field must be a map with string keys or a *Compound, not a %v", rest.Type()))
+	}
+	if rest.IsNil() {
+		rest.Set(reflect.MakeMap(rest.Type()))
+	}
 
-	case TAG_Byte_Array:
-		var length uint32
-		d.r(&length)
+	// An empty interface gets a tree value, which keeps the exact tag type so Marshal can write
+	// it back.
+	var val reflect.Value
+	if elem := rest.Type().Elem(); elem.Kind() == reflect.Interface && elem.NumMethod() == 0 {
+		val = reflect.ValueOf(d.readTree(tag))
+	} else {
+		val = reflect.New(elem).Elem()
+		d.readValue(tag, val)
+	}
+	rest.SetMapIndex(reflect.ValueOf(name).Convert(rest.Type().Key()), val)
+}
 
-		switch v.Kind() {
-		case reflect.Array, reflect.Slice:
-			if v.Kind() == reflect.Array {
-				if uint32(v.Len()) < length {
-					panic(fmt.Errorf("nbt: Byte array is of length %d, but only the array given is only %d long!", length, v.Len()))
-				}
-			} else {
-				if uint32(v.Len()) < length {
-					v.Set(reflect.MakeSlice(v.Type(), int(length), int(length)))
-				}
-			}
+func (d *decodeState) readValue(tag Tag, v reflect.Value) {
+	decoderFor(v.Type())(d, tag, v)
+}
 
-			for i := 0; i < int(length); i++ {
-				value := v.Index(i)
-				d.readValue(TAG_Byte, value)
+// Reads a tag that can't be stored in v. Numbers may still be converted; for everything else,
+// the header of the payload is read before the error is reported.
+func (d *decodeState) mismatch(tag Tag, v reflect.Value) {
+	switch tag {
+	case TAG_Byte, TAG_Short, TAG_Int, TAG_Long:
+		d.convertInt(tag, v, d.readInt(tag))
+	case TAG_Float, TAG_Double:
+		d.convertFloat(tag, v, d.readFloat(tag))
+	case TAG_Byte_Array, TAG_Int_Array, TAG_Long_Array:
+		d.checkLength(tag, d.readLength())
+		panic(d.typeError(tag, v))
+	case TAG_List:
+		d.readByte()
+		d.checkLength(tag, d.readLength())
+		panic(d.typeError(tag, v))
+	case TAG_String, TAG_Compound:
+		panic(d.typeError(tag, v))
+	default:
+		panic(d.errorf("nbt: Unhandled tag: %s", tag))
+	}
+}
+
+func (d *decodeState) readInterface(tag Tag, v reflect.Value) {
+	value := d.allocate(tag)
+	d.readValue(tag, value)
+	v.Set(value)
+}
+
+func (d *decodeState) readStringValue(tag Tag, v reflect.Value) {
+	if tag != TAG_String {
+		d.mismatch(tag, v)
+		return
+	}
+	v.SetString(d.readString())
+}
+
+// Reads an array or list tag into a Go array or slice.
+func (d *decodeState) readSequence(tag Tag, v reflect.Value) {
+	switch tag {
+	case TAG_Byte_Array, TAG_Int_Array, TAG_Long_Array:
+		length := d.readLength()
+		d.checkLength(tag, length)
+
+		if v.Kind() == reflect.Array {
+			if uint32(v.Len()) < length {
+				panic(d.errorf("nbt: %s array is of length %d, but only the array given is only %d long!", arrayName(tag), length, v.Len()))
 			}
+		} else if uint32(v.Cap()) < length {
+			n := preallocLength(length)
+			v.Set(reflect.MakeSlice(v.Type(), n, n))
+		} else {
+			v.Set(v.Slice(0, int(length)))
+		}
 
-		default:
-			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
+		if tag == TAG_Byte_Array && isPlainBytes(v.Type()) && v.CanAddr() {
+			d.readPlainBytes(v, int(length))
+			return
 		}
 
-	case TAG_String:
-		switch v.Kind() {
-		case reflect.String:
-			v.SetString(d.readString())
-		default:
-			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
+		elem := arrayElem(tag)
+		decode := decoderFor(v.Type().Elem())
+		for i := 0; i < int(length); i++ {
+			growSlice(v, i, int(length))
+			decode(d, elem, v.Index(i))
 		}
 
 	case TAG_List:
-		var inner Tag
-		d.r(&inner)
-		var length uint32
-		d.r(&length)
+		inner := Tag(d.readByte())
+		length := d.readLength()
+		d.checkLength(tag, length)
 
-		switch v.Kind() {
-		case reflect.Slice:
-			if uint32(v.Cap()) < length {
-				v.Set(reflect.MakeSlice(v.Type(), 0, int(length)))
-			} else {
-				v.Set(v.Slice(0, 0))
+		if v.Kind() == reflect.Array {
+			if uint32(v.Len()) < length {
+				panic(d.errorf("nbt: List is of length %d, but the array given is only %d long!", length, v.Len()))
 			}
-			kind := v.Type().Elem()
-
-			var i uint32
-			defer func() {
-				if r := recover(); r != nil {
-					panic(fmt.Errorf("%v\n\t\tat list index %d", r, i))
-				}
-			}()
-
-			for i = 0; i < length; i++ {
-				var value reflect.Value
-				if kind.Kind() == reflect.Ptr {
-					value = reflect.New(kind.Elem())
-					d.readValue(inner, value.Elem())
-				} else {
-					if kind.Kind() == reflect.Interface {
-						value = d.allocate(inner)
-					} else {
-						value = reflect.New(kind).Elem()
-					}
-					d.readValue(inner, value)
-				}
-				v.Set(reflect.Append(v, value))
+		} else if uint32(v.Cap()) < length {
+			n := preallocLength(length)
+			v.Set(reflect.MakeSlice(v.Type(), n, n))
+		} else {
+			v.Set(v.Slice(0, int(length)))
+			zero := reflect.Zero(v.Type().Elem())
+			for i := 0; i < int(length); i++ {
+				v.Index(i).Set(zero)
 			}
-
-		default:
-			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
 		}
+		if inner == TAG_Byte && isPlainBytes(v.Type()) && v.CanAddr() {
+			d.readPlainBytes(v, int(length))
+			return
+		}
+		decode := decoderFor(v.Type().Elem())
 
-	case TAG_Compound:
-		switch v.Kind() {
-		case reflect.Struct:
-			fields := parseStruct(v)
-
-			var name string
-			defer func() {
-				if r := recover(); r != nil {
-					panic(fmt.Errorf("%v\n\t\tat struct field %#v", r, name))
-				}
-			}()
-
-			for {
-				var tag Tag
-				name, tag = d.readTag()
-				if tag == TAG_End {
-					break
-				}
-				if field, ok := fields[name]; ok {
-					d.readValue(tag, field)
-				} else {
-					panic(fmt.Errorf("nbt: Unhandled %s", tag))
-				}
-			}
-
-		case reflect.Map:
-			if v.IsNil() {
-				v.Set(reflect.ValueOf(make(map[string]interface{})))
-			}
+		d.enter()
+		defer d.leave()
 
-			var name string
-			defer func() {
-				if r := recover(); r != nil {
-					panic(fmt.Errorf("%v\n\t\tat struct field %#v", r, name))
-				}
-			}()
-
-			for {
-				var tag Tag
-				name, tag = d.readTag()
-				if tag == TAG_End {
-					break
-				}
-				val := d.allocate(tag)
-				d.readValue(tag, val)
-				v.SetMapIndex(reflect.ValueOf(name), val)
+		var i uint32
+		defer func() {
+			if r := recover(); r != nil {
+				d.panicAt(r, indexSegment(int(i)))
 			}
+		}()
 
-		default:
-			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
+		for i = 0; i < length; i++ {
+			growSlice(v, int(i), int(length))
+			decode(d, inner, v.Index(int(i)))
 		}
 
+	default:
+		d.mismatch(tag, v)
+	}
+}
+
+func arrayName(tag Tag) string {
+	switch tag {
 	case TAG_Int_Array:
-		var length uint32
-		d.r(&length)
+		return "Int"
+	case TAG_Long_Array:
+		return "Long"
+	}
+	return "Byte"
+}
 
-		switch v.Kind() {
-		case reflect.Array, reflect.Slice:
-			if v.Kind() == reflect.Array {
-				if uint32(v.Len()) < length {
-					panic(fmt.Errorf("nbt: Int array is of length %d, but only the array given is only %d long!", length, v.Len()))
-				}
-			} else {
-				if uint32(v.Len()) < length {
-					v.Set(reflect.MakeSlice(v.Type(), int(length), int(length)))
-				}
-			}
+func (d *decodeState) readStruct(tag Tag, v reflect.Value) {
+	if tag != TAG_Compound {
+		d.mismatch(tag, v)
+		return
+	}
+	info := getStructInfo(v.Type())
 
-			for i := 0; i < int(length); i++ {
-				value := v.Index(i)
-				d.readValue(TAG_Int, value)
-			}
+	d.enter()
+	defer d.leave()
 
-		default:
-			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
+	var name string
+	defer func() {
+		if r := recover(); r != nil {
+			d.panicAt(r, fieldSegment(name))
 		}
+	}()
 
-	default:
-		panic(fmt.Errorf("nbt: Unhandled tag: %s", tag))
+	for {
+		var tag Tag
+		name, tag = d.readTag()
+		if tag == TAG_End {
+			break
+		}
+		if i, ok := info.byName[name]; ok {
+			d.readField(tag, v, info.fields[i])
+		} else if info.rest != nil {
+			d.readRest(fieldByIndex(v, info.rest), name, tag)
+		} else if d.disallowUnknownFields {
+			panic(&DecodeError{Msg: fmt.Sprintf("nbt: Unhandled %s", tag), Actual: tag, Offset: d.offset})
+		} else {
+			d.skipValue(tag)
+		}
+	}
+}
+
+func (d *decodeState) readMap(tag Tag, v reflect.Value) {
+	if tag != TAG_Compound || v.Type().Key().Kind() != reflect.String {
+		d.mismatch(tag, v)
+		return
+	}
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(v.Type()))
+	}
+	key := v.Type().Key()
+	elem := v.Type().Elem()
+	decode := decoderFor(elem)
+
+	d.enter()
+	defer d.leave()
+
+	var name string
+	defer func() {
+		if r := recover(); r != nil {
+			d.panicAt(r, fieldSegment(name))
+		}
+	}()
+
+	for {
+		var tag Tag
+		name, tag = d.readTag()
+		if tag == TAG_End {
+			break
+		}
+		val := reflect.New(elem).Elem()
+		decode(d, tag, val)
+		v.SetMapIndex(reflect.ValueOf(name).Convert(key), val)
 	}
 }
@@ -1,6 +1,10 @@
 package nbt
 
 import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
 	"os"
 	"reflect"
 	"testing"
@@ -196,7 +200,7 @@ func TestBigTest(t *testing.T) {
 	expected := BigTest{
 		ByteTest:   127,
 		ShortTest:  32767,
-		IntTest:    -1,
+		IntTest:    2147483647,
 		LongTest:   9223372036854775807,
 		FloatTest:  0.49823147,
 		DoubleTest: 0.4931287132182315,
@@ -311,3 +315,388 @@ func TestBigTest(t *testing.T) {
 		}
 	}
 }
+
+type LongArrayTest struct {
+	DataVersion int32
+	Heightmaps  struct {
+		MotionBlocking [37]int64 `nbt:"MOTION_BLOCKING"`
+		WorldSurface   []uint64  `nbt:"WORLD_SURFACE"`
+	}
+	LongArray      []int64   `nbt:"longArrayTest (the first 64 values of n*n*0x0123456789abcdef+n*7)"`
+	EmptyLongArray []int64   `nbt:"emptyLongArrayTest"`
+	IntArray       []int32   `nbt:"intArrayTest"`
+	LongArrayList  [][]int64 `nbt:"listTest (long array)"`
+}
+
+func TestLongArray(t *testing.T) {
+	f, err := os.Open("testcases/longarraytest.nbt")
+	if err != nil {
+		t.Error(err)
+	}
+	defer f.Close()
+
+	var test LongArrayTest
+
+	err = Unmarshal(GZip, f, &test)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if test.DataVersion != 3953 {
+		t.Errorf("DataVersion == %d != 3953", test.DataVersion)
+	}
+	for i, height := range test.Heightmaps.MotionBlocking {
+		if expected := 0x0100804020100804 * int64(i+1); height != expected {
+			t.Errorf("MOTION_BLOCKING[%d] == %d != %d", i, height, expected)
+		}
+	}
+	if len(test.Heightmaps.WorldSurface) != 37 || test.Heightmaps.WorldSurface[0] != 0x0100804020100804*37 {
+		t.Errorf("WORLD_SURFACE == %v", test.Heightmaps.WorldSurface)
+	}
+	if len(test.LongArray) != 64 {
+		t.Errorf("Long array length is %d, but expected 64.", len(test.LongArray))
+	}
+	for i, value := range test.LongArray {
+		n := int64(i)
+		if expected := n*n*0x0123456789abcdef + n*7; value != expected {
+			t.Errorf("longArrayTest[%d] == %d != %d", i, value, expected)
+		}
+	}
+	if len(test.EmptyLongArray) != 0 {
+		t.Errorf("emptyLongArrayTest == %v", test.EmptyLongArray)
+	}
+	for i, value := range test.IntArray {
+		n := int32(i)
+		if expected := (n*n*255+n*7)%100 - 50; value != expected {
+			t.Errorf("intArrayTest[%d] == %d != %d", i, value, expected)
+		}
+	}
+	expected := [][]int64{{1, -1}, {-9223372036854775808, 9223372036854775807, 0}}
+	if !reflect.DeepEqual(test.LongArrayList, expected) {
+		t.Errorf("listTest (long array) == %v != %v", test.LongArrayList, expected)
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	f, err := os.Open("testcases/servers.dat")
+	if err != nil {
+		t.Error(err)
+	}
+	defer f.Close()
+
+	var list WronglyTypedServerList
+
+	err = Unmarshal(Uncompressed, f, &list)
+	e, ok := err.(*DecodeError)
+	if !ok {
+		t.Fatalf("Error is a %T, but expected *DecodeError", err)
+	}
+
+	expected := []PathSegment{{Name: "servers", Index: -1}, {Index: 0}, {Name: "ip", Index: -1}}
+	if !reflect.DeepEqual(e.Path, expected) {
+		t.Errorf("Path == %v, but expected %v", e.Path, expected)
+	}
+	if e.Expected != TAG_Double || e.Actual != TAG_String {
+		t.Errorf("Expected == %s, Actual == %s", e.Expected, e.Actual)
+	}
+	if e.Type != reflect.TypeOf(float64(0)) {
+		t.Errorf("Type == %v", e.Type)
+	}
+	// The error happens right after the name of "ip" is read, before its payload.
+	if e.Offset != 0x23 {
+		t.Errorf("Offset == %#x, but expected 0x23", e.Offset)
+	}
+}
+
+func TestDecodeErrorTruncated(t *testing.T) {
+	data, err := ioutil.ReadFile("testcases/servers.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var list ServerList
+	err = Unmarshal(Uncompressed, bytes.NewReader(data[:0x30]), &list)
+	e, ok := err.(*DecodeError)
+	if !ok {
+		t.Fatalf("Error is a %T, but expected *DecodeError", err)
+	}
+	if e.Err != io.ErrUnexpectedEOF {
+		t.Errorf("Err == %v, but expected io.ErrUnexpectedEOF", e.Err)
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("errors.Is(err, io.ErrUnexpectedEOF) is false")
+	}
+	if e.Offset != 0x30 {
+		t.Errorf("Offset == %#x, but expected 0x30", e.Offset)
+	}
+	if len(e.Path) != 3 || e.Path[2].Name != "ip" {
+		t.Errorf("Path == %v", e.Path)
+	}
+}
+
+func TestDecodeHugeLength(t *testing.T) {
+	for _, test := range []struct {
+		data []byte
+		v    interface{}
+	}{
+		// A root compound holding an entry "l" of length 0x7fffffff, with none of its elements there.
+		{[]byte{10, 0, 0, 9, 0, 1, 'l', 10, 0x7f, 0xff, 0xff, 0xff}, new(Value)},
+		{[]byte{10, 0, 0, 9, 0, 1, 'l', 10, 0x7f, 0xff, 0xff, 0xff}, new(struct {
+			L []struct{ X int32 } `nbt:"l"`
+		})},
+		{[]byte{10, 0, 0, 7, 0, 1, 'l', 0x7f, 0xff, 0xff, 0xff, 1, 2}, new(Value)},
+		{[]byte{10, 0, 0, 7, 0, 1, 'l', 0x7f, 0xff, 0xff, 0xff, 1, 2}, new(struct {
+			L []byte `nbt:"l"`
+		})},
+		{[]byte{10, 0, 0, 9, 0, 1, 'l', 1, 0x7f, 0xff, 0xff, 0xff, 1, 2}, new(struct {
+			L []int8 `nbt:"l"`
+		})},
+		{[]byte{10, 0, 0, 11, 0, 1, 'l', 0x7f, 0xff, 0xff, 0xff}, new(Value)},
+		{[]byte{10, 0, 0, 12, 0, 1, 'l', 0x7f, 0xff, 0xff, 0xff}, new(struct {
+			L []int64 `nbt:"l"`
+		})},
+	} {
+		err := Unmarshal(Uncompressed, bytes.NewReader(test.data), test.v)
+		if e, ok := err.(*DecodeError); !ok || e.Err != io.ErrUnexpectedEOF {
+			t.Errorf("% x into %T: Error is %v, but expected io.ErrUnexpectedEOF", test.data, test.v, err)
+		}
+	}
+}
+
+type RestServer struct {
+	Name string                 `nbt:"name"`
+	Rest map[string]interface{} `nbt:",rest"`
+}
+
+type RestTreeServer struct {
+	IP   string    `nbt:"ip"`
+	Rest *Compound `nbt:",rest"`
+}
+
+func TestRestField(t *testing.T) {
+	f, err := os.Open("testcases/servers.dat")
+	if err != nil {
+		t.Error(err)
+	}
+	defer f.Close()
+
+	var list struct {
+		Servers []RestServer `nbt:"servers"`
+	}
+
+	err = Unmarshal(Uncompressed, f, &list)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list.Servers) != 3 {
+		t.Fatalf("Server list length is %d, but expected 3.", len(list.Servers))
+	}
+	assertString(t, "Servers[0].Name", list.Servers[0].Name, "Who")
+	assertString(t, "Servers[0].Rest[ip]", string(list.Servers[0].Rest["ip"].(String)), "what.invalid")
+	if len(list.Servers[0].Rest) != 1 {
+		t.Errorf("Servers[0].Rest == %v", list.Servers[0].Rest)
+	}
+}
+
+func TestRestFieldRoundTrip(t *testing.T) {
+	data, err := ioutil.ReadFile("testcases/servers.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var list struct {
+		Servers []RestTreeServer `nbt:"servers"`
+	}
+	if err = Unmarshal(Uncompressed, bytes.NewReader(data), &list); err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := list.Servers[2].Rest.GetString("name"); name != "☃" {
+		t.Errorf("Servers[2].Rest[name] == %#v", name)
+	}
+
+	list.Servers[1].IP = "why:54321"
+
+	var encoded bytes.Buffer
+	if err = Marshal(Uncompressed, &encoded, list); err != nil {
+		t.Fatal(err)
+	}
+
+	var result ServerList
+	if err = Unmarshal(Uncompressed, &encoded, &result); err != nil {
+		t.Fatal(err)
+	}
+	assertString(t, "Servers[1].Name", result.Servers[1].Name, "Where")
+	assertString(t, "Servers[1].IP", result.Servers[1].IP, "why:54321")
+	assertString(t, "Servers[2].Name", result.Servers[2].Name, "☃")
+
+	// The map form keeps the tag types too. Map entries have no order, so compare them by name.
+	entity := new(Compound)
+	entity.SetString("name", "Bob")
+	entity.SetList("Pos", &List{Elem: TAG_Double, Values: []Value{Double(0.5), Double(64), Double(-3)}})
+	entity.SetByteArray("Data", []byte{1, 2, 3})
+	entity.SetShort("Air", 300)
+	encoded.Reset()
+	if err = Marshal(Uncompressed, &encoded, entity); err != nil {
+		t.Fatal(err)
+	}
+
+	var server RestServer
+	if err = Unmarshal(Uncompressed, &encoded, &server); err != nil {
+		t.Fatal(err)
+	}
+	encoded.Reset()
+	if err = Marshal(Uncompressed, &encoded, server); err != nil {
+		t.Fatal(err)
+	}
+	var tree *Compound
+	if err = Unmarshal(Uncompressed, &encoded, &tree); err != nil {
+		t.Fatal(err)
+	}
+	if tree.Len() != entity.Len() {
+		t.Errorf("Round trip through the map gave %v, but expected %v", tree.Names(), entity.Names())
+	}
+	for _, name := range entity.Names() {
+		if !reflect.DeepEqual(tree.Get(name), entity.Get(name)) {
+			t.Errorf("%s == %#v, but expected %#v", name, tree.Get(name), entity.Get(name))
+		}
+	}
+}
+
+func TestAutoDetect(t *testing.T) {
+	data := readTestcase(t, "bigtest.nbt", GZip)
+
+	var expected *Compound
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &expected); err != nil {
+		t.Fatal(err)
+	}
+
+	gzipped, err := ioutil.ReadFile("testcases/bigtest.nbt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var zlibbed bytes.Buffer
+	if err = Marshal(ZLib, &zlibbed, expected); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, input := range map[string][]byte{
+		"uncompressed": data,
+		"gzip":         gzipped,
+		"zlib":         zlibbed.Bytes(),
+	} {
+		var result *Compound
+		if err := Unmarshal(AutoDetect, bytes.NewReader(input), &result); err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if !reflect.DeepEqual(result, expected) {
+			t.Errorf("%s: Decoded %v, but expected %v", name, result.Names(), expected.Names())
+		}
+	}
+
+	var result *Compound
+	err = Unmarshal(AutoDetect, bytes.NewReader([]byte("PK\x03\x04")), &result)
+	if err == nil || err.Error() != "nbt: Unable to detect the compression of the input, which starts with 0x504b" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+type NamedBigTest struct {
+	Name string `nbt:",rootname"`
+	BigTest
+}
+
+type Schematic struct {
+	Name   string `nbt:"Schematic,rootname"`
+	Width  int16
+	Height int16
+}
+
+func TestRootName(t *testing.T) {
+	data := readTestcase(t, "bigtest.nbt", GZip)
+
+	var tree *Compound
+	if name, err := UnmarshalNamed(Uncompressed, bytes.NewReader(data), &tree); err != nil {
+		t.Fatal(err)
+	} else if name != "Level" {
+		t.Errorf("Root name == %#v", name)
+	}
+
+	var bigTest NamedBigTest
+	if _, err := UnmarshalNamed(Uncompressed, bytes.NewReader(data), &bigTest); err != nil {
+		t.Fatal(err)
+	}
+	if bigTest.Name != "Level" || bigTest.IntTest != 2147483647 {
+		t.Errorf("Decoded %#v", bigTest)
+	}
+
+	// The rootname field isn't an entry of the compound, and its value is the name of the root.
+	var buf bytes.Buffer
+	if err := Marshal(Uncompressed, &buf, bigTest); err != nil {
+		t.Fatal(err)
+	}
+	if name := rootName(buf.Bytes()); name != "Level" {
+		t.Errorf("Root name == %#v", name)
+	}
+	var check map[string]interface{}
+	if err := Unmarshal(Uncompressed, &buf, &check); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := check["Name"]; ok || len(check) != 11 {
+		t.Errorf("Encoded entries %v", check)
+	}
+
+	// An empty field falls back to the name in the struct tag.
+	buf.Reset()
+	if err := Marshal(Uncompressed, &buf, &Schematic{Width: 1, Height: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if name := rootName(buf.Bytes()); name != "Schematic" {
+		t.Errorf("Root name == %#v", name)
+	}
+
+	// An explicit name beats both.
+	buf.Reset()
+	if err := MarshalNamed(Uncompressed, &buf, "Other", Schematic{Name: "Field"}); err != nil {
+		t.Fatal(err)
+	}
+	if name := rootName(buf.Bytes()); name != "Other" {
+		t.Errorf("Root name == %#v", name)
+	}
+
+	enc := NewEncoder(&buf)
+	enc.SetRootName("Stream")
+	enc.Encode(map[string]int8{})
+	enc.Close()
+
+	dec := NewDecoder(&buf)
+	var schematic Schematic
+	if name, err := dec.DecodeNamed(&schematic); err != nil || name != "Other" || schematic.Name != "Other" {
+		t.Errorf("Decoded %#v, %#v, %v", name, schematic, err)
+	}
+	if name, err := dec.DecodeNamed(&schematic); err != nil || name != "Stream" || schematic.Name != "Stream" {
+		t.Errorf("Decoded %#v, %#v, %v", name, schematic, err)
+	}
+}
+
+func TestDecodeIntoLongerSlice(t *testing.T) {
+	root := new(Compound)
+	root.SetByteArray("Bytes", []byte{1, 2})
+	root.SetIntArray("Ints", []int32{1, 2})
+	root.SetLongArray("Longs", []int64{1, 2})
+	root.SetList("List", &List{Elem: TAG_Int, Values: []Value{Int(1), Int(2)}})
+	data := encodeTree(t, root)
+
+	v := struct {
+		Bytes []byte
+		Ints  []int32
+		Longs []int64
+		List  []int32
+	}{[]byte{9, 9, 9, 9}, []int32{9, 9, 9, 9}, []int64{9, 9, 9, 9}, []int32{9, 9, 9, 9}}
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &v); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v.Bytes, []byte{1, 2}) || !reflect.DeepEqual(v.Ints, []int32{1, 2}) ||
+		!reflect.DeepEqual(v.Longs, []int64{1, 2}) || !reflect.DeepEqual(v.List, []int32{1, 2}) {
+		t.Errorf("Decoded %v", v)
+	}
+}
@@ -1,22 +1,36 @@
 package nbt
 
 import (
-	"compress/gzip"
-	"compress/zlib"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
 	"reflect"
 )
 
-func Marshal(compression Compression, out io.Writer, v interface{}) (err error) {
+// Writes v as a root tag to out. Errors are of type *EncodeError.
+//
+// The root tag has an empty name, unless v is a struct with a field tagged `nbt:",rootname"`.
+// Then the name is the value of that field, or if it is empty, the name in its struct tag, as
+// with `nbt:"Level,rootname"`.
+func Marshal(compression Compression, out io.Writer, v interface{}) error {
+	return marshal(compression, DefaultCompression, out, "", v)
+}
+
+// Like Marshal, but with a compression level such as BestSpeed or BestCompression.
+func MarshalLevel(compression Compression, level int, out io.Writer, v interface{}) error {
+	return marshal(compression, level, out, "", v)
+}
+
+// Like Marshal, but with the name of the root tag. An empty name means the same as for Marshal.
+func MarshalNamed(compression Compression, out io.Writer, name string, v interface{}) error {
+	return marshal(compression, DefaultCompression, out, name, v)
+}
+
+func marshal(compression Compression, level int, out io.Writer, name string, v interface{}) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			if s, ok := r.(string); ok {
-				err = fmt.Errorf(s)
-			} else {
-				err = r.(error)
-			}
+			err = encodeError(r)
 		}
 	}()
 
@@ -24,279 +38,416 @@ func Marshal(compression Compression, out io.Writer, v interface{}) (err error)
 		panic(fmt.Errorf("nbt: Output stream is nil"))
 	}
 
-	switch compression {
-	case Uncompressed:
-		break
-	case GZip:
-		w := gzip.NewWriter(out)
-		defer w.Close()
-		out = w
-	case ZLib:
-		w := zlib.NewWriter(out)
-		defer w.Close()
-		out = w
-	default:
-		panic(fmt.Errorf("nbt: Unknown compression type: %d", compression))
+	c := compress(compression, level, out)
+	if c != nil {
+		out = c
 	}
 
-	writeRootTag(out, reflect.ValueOf(v))
+	newEncodeState(out).writeRootTag(name, reflect.ValueOf(v))
 
+	if c != nil {
+		if err := c.Close(); err != nil {
+			panic(err)
+		}
+	}
 	return
 }
 
-func writeRootTag(out io.Writer, v reflect.Value) {
-	writeTag(out, "", v)
+type encodeState struct {
+	out    io.Writer
+	order  binary.ByteOrder
+	varint bool // Write ints, longs and lengths as varints, as in Bedrock's network protocol.
+
+	nameless bool // Write the root tag without a name, as in Java's network protocol.
+
+	buf [binary.MaxVarintLen64]byte // Scratch space for numbers, so writing them doesn't allocate.
 }
 
-func w(out io.Writer, v interface{}) {
-	err := binary.Write(out, binary.BigEndian, v)
-	if err != nil {
-		panic(err)
-	}
+func newEncodeState(out io.Writer) *encodeState {
+	return &encodeState{out: out, order: binary.BigEndian}
 }
 
-func writeTag(out io.Writer, name string, v reflect.Value) {
-	v = reflect.Indirect(v)
-	defer func() {
-		if r := recover(); r != nil {
-			panic(fmt.Errorf("%v\n\t\tat struct field %#v", r, name))
-		}
-	}()
-	for v.Kind() == reflect.Interface {
-		v = v.Elem()
+func (e *encodeState) write(p []byte) {
+	if _, err := e.out.Write(p); err != nil {
+		panic(err)
 	}
-	switch v.Kind() {
-	case reflect.Bool:
-		w(out, TAG_Byte)
-		writeValue(out, TAG_String, name)
-		if v.Bool() {
-			writeValue(out, TAG_Byte, byte(1))
-		} else {
-			writeValue(out, TAG_Byte, byte(0))
-		}
+}
 
-	case reflect.Int8:
-		w(out, TAG_Byte)
-		writeValue(out, TAG_String, name)
-		writeValue(out, TAG_Byte, int8(v.Int()))
-
-	case reflect.Uint8:
-		w(out, TAG_Byte)
-		writeValue(out, TAG_String, name)
-		writeValue(out, TAG_Byte, uint8(v.Uint()))
-
-	case reflect.Int16:
-		w(out, TAG_Short)
-		writeValue(out, TAG_String, name)
-		writeValue(out, TAG_Short, int16(v.Int()))
-
-	case reflect.Uint16:
-		w(out, TAG_Short)
-		writeValue(out, TAG_String, name)
-		writeValue(out, TAG_Short, uint16(v.Uint()))
-
-	case reflect.Int32:
-		w(out, TAG_Int)
-		writeValue(out, TAG_String, name)
-		writeValue(out, TAG_Int, int32(v.Int()))
-
-	case reflect.Uint32:
-		w(out, TAG_Int)
-		writeValue(out, TAG_String, name)
-		writeValue(out, TAG_Int, uint32(v.Uint()))
-
-	case reflect.Int64:
-		w(out, TAG_Long)
-		writeValue(out, TAG_String, name)
-		writeValue(out, TAG_Long, v.Int())
-
-	case reflect.Uint64:
-		w(out, TAG_Long)
-		writeValue(out, TAG_String, name)
-		writeValue(out, TAG_Long, v.Uint())
-
-	case reflect.Float32:
-		w(out, TAG_Float)
-		writeValue(out, TAG_String, name)
-		writeValue(out, TAG_Float, float32(v.Float()))
-
-	case reflect.Float64:
-		w(out, TAG_Double)
-		writeValue(out, TAG_String, name)
-		writeValue(out, TAG_Double, v.Float())
-
-	case reflect.String:
-		w(out, TAG_String)
-		writeValue(out, TAG_String, name)
-		writeValue(out, TAG_String, v.String())
-
-	case reflect.Array:
-		switch v.Type().Elem().Kind() {
-		case reflect.Uint8:
-			w(out, TAG_Byte_Array)
-			writeValue(out, TAG_String, name)
-			writeValue(out, TAG_Byte_Array, v.Slice(0, v.Len()).Bytes())
-
-		case reflect.Int32, reflect.Uint32:
-			w(out, TAG_Int_Array)
-			writeValue(out, TAG_String, name)
-			for i := 0; i < v.Len(); i++ {
-				writeValue(out, TAG_Int, v.Index(i).Interface())
-			}
+func (e *encodeState) writeByte(b byte) {
+	e.buf[0] = b
+	e.write(e.buf[:1])
+}
 
-		default:
-			panic(fmt.Errorf("nbt: Unhandled array type: %v", v.Type().Elem()))
-		}
+func (e *encodeState) writeUint16(u uint16) {
+	e.order.PutUint16(e.buf[:2], u)
+	e.write(e.buf[:2])
+}
 
-	case reflect.Slice:
-		w(out, TAG_List)
-		writeValue(out, TAG_String, name)
-		writeList(out, v)
+func (e *encodeState) writeUint32(u uint32) {
+	e.order.PutUint32(e.buf[:4], u)
+	e.write(e.buf[:4])
+}
 
-	case reflect.Map:
-		w(out, TAG_Compound)
-		writeValue(out, TAG_String, name)
-		writeMap(out, v)
+func (e *encodeState) writeUint64(u uint64) {
+	e.order.PutUint64(e.buf[:8], u)
+	e.write(e.buf[:8])
+}
 
-	case reflect.Struct:
-		w(out, TAG_Compound)
-		writeValue(out, TAG_String, name)
-		writeCompound(out, v)
+func (e *encodeState) writeUvarint(u uint64) {
+	n := binary.PutUvarint(e.buf[:], u)
+	e.write(e.buf[:n])
+}
 
-	default:
-		panic(fmt.Errorf("nbt: Unhandled type: %v (%v)", v.Type(), v.Interface()))
-	}
+// Writes a zig-zag encoded varint.
+func (e *encodeState) writeVarint(i int64) {
+	e.writeUvarint(uint64(i<<1) ^ uint64(i>>63))
 }
 
-func writeValue(out io.Writer, tag Tag, v interface{}) {
+// Writes the payload of an integer tag. Signed values are passed as their two's complement.
+func (e *encodeState) writeInt(tag Tag, u uint64) {
 	switch tag {
-	case TAG_Byte, TAG_Short, TAG_Int, TAG_Long, TAG_Float, TAG_Double:
-		w(out, v)
-
-	case TAG_String:
-		w(out, uint16(len(v.(string))))
-		_, err := out.Write([]byte(v.(string)))
-		if err != nil {
-			panic(err)
+	case TAG_Byte:
+		e.writeByte(byte(u))
+	case TAG_Short:
+		e.writeUint16(uint16(u))
+	case TAG_Int:
+		if e.varint {
+			e.writeVarint(int64(int32(u)))
+		} else {
+			e.writeUint32(uint32(u))
 		}
-
-	case TAG_Byte_Array:
-		w(out, uint32(len(v.([]byte))))
-		_, err := out.Write(v.([]byte))
-		if err != nil {
-			panic(err)
+	case TAG_Long:
+		if e.varint {
+			e.writeVarint(int64(u))
+		} else {
+			e.writeUint64(u)
 		}
-
 	default:
-		panic(fmt.Errorf("nbt: Unhandled tag: %s (%v)", tag, v))
+		panic(fmt.Errorf("nbt: Unhandled tag: %s", tag))
 	}
 }
 
-func writeList(out io.Writer, v reflect.Value) {
-	var tag Tag
-	mustConvertBool := false
-	mustConvertMap := false
-	switch v.Type().Elem().Kind() {
-	case reflect.Bool:
-		mustConvertBool = true
-		fallthrough
-	case reflect.Int8, reflect.Uint8:
-		tag = TAG_Byte
+// Writes the payload of a floating point tag.
+func (e *encodeState) writeFloat(tag Tag, f float64) {
+	switch tag {
+	case TAG_Float:
+		e.writeUint32(math.Float32bits(float32(f)))
+	case TAG_Double:
+		e.writeUint64(math.Float64bits(f))
+	default:
+		panic(fmt.Errorf("nbt: Unhandled tag: %s", tag))
+	}
+}
 
-	case reflect.Int16, reflect.Uint16:
-		tag = TAG_Short
+// Writes the length of an array or list.
+func (e *encodeState) writeLength(n int) {
+	if e.varint {
+		e.writeVarint(int64(int32(n)))
+	} else {
+		e.writeUint32(uint32(n))
+	}
+}
 
-	case reflect.Int32, reflect.Uint32:
-		tag = TAG_Int
+func (e *encodeState) writeString(s string) {
+	if e.varint {
+		e.writeUvarint(uint64(len(s)))
+	} else {
+		e.writeUint16(uint16(len(s)))
+	}
+	if _, err := io.WriteString(e.out, s); err != nil {
+		panic(err)
+	}
+}
 
-	case reflect.Int64, reflect.Uint64:
-		tag = TAG_Long
+func (e *encodeState) writeHeader(tag Tag, name string) {
+	e.writeByte(byte(tag))
+	e.writeString(name)
+}
 
-	case reflect.Float32:
-		tag = TAG_Float
+func (e *encodeState) writeRootTag(name string, v reflect.Value) {
+	if !e.nameless {
+		if name == "" {
+			name = rootNameOf(v)
+		}
+		e.writeTag(name, v)
+		return
+	}
 
-	case reflect.Float64:
-		tag = TAG_Double
+	// Without a name, a nil root is written as a lone TAG_End, which means the value is absent.
+	if isNilRoot(v) {
+		e.writeByte(byte(TAG_End))
+		return
+	}
+	e.writeNamedTag("", false, v)
+}
 
-	case reflect.String:
-		tag = TAG_String
+// Reports whether v is nil or a chain of pointers and interfaces ending in nil.
+func isNilRoot(v reflect.Value) bool {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return true
+		}
+		v = v.Elem()
+	}
+	return !v.IsValid()
+}
 
-	case reflect.Array:
-		switch v.Type().Elem().Elem().Kind() {
-		case reflect.Uint8:
-			tag = TAG_Byte_Array
+func (e *encodeState) writeTag(name string, v reflect.Value) {
+	e.writeNamedTag(name, true, v)
+}
 
-		case reflect.Int32, reflect.Uint32:
-			tag = TAG_Int_Array
+// Writes a tag, leaving out its name if named is false.
+func (e *encodeState) writeNamedTag(name string, named bool, v reflect.Value) {
+	v = reflect.Indirect(v)
+	for v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		panic(unhandledType(nil, "nbt: Unhandled nil value"))
+	}
 
-		default:
-			panic(fmt.Errorf("nbt: Unhandled array type: %v", v.Type().Elem().Elem()))
+	header := func(tag Tag) {
+		e.writeByte(byte(tag))
+		if named {
+			e.writeString(name)
 		}
+	}
+
+	c := encoderFor(v.Type())
+	switch {
+	case c.marshaler:
+		m, _ := asMarshaler(v)
+		tag, payload := e.marshal(m)
+		header(tag)
+		e.write(payload)
+	case c.tag == TAG_End:
+		c.encode(e, v)
+	default:
+		header(c.tag)
+		c.encode(e, v)
+	}
+}
 
-	case reflect.Slice:
-		tag = TAG_List
+// Writes a byte, int or long array.
+func (e *encodeState) writeArray(v reflect.Value) {
+	e.writeLength(v.Len())
 
-	case reflect.Map:
-		mustConvertMap = true
-		fallthrough
-	case reflect.Struct:
-		tag = TAG_Compound
+	elem := arrayElem(tagFor(v.Type()))
+	if elem == TAG_Byte && v.CanAddr() {
+		e.write(v.Slice(0, v.Len()).Bytes())
+		return
+	}
 
-	case reflect.Ptr: // TODO: Is there ever a case where TAG_Compound would be wrong here?
-		tag = TAG_Compound
+	encode := encoderFor(v.Type().Elem()).encode
+	for i := 0; i < v.Len(); i++ {
+		encode(e, v.Index(i))
+	}
+}
 
-	default:
-		panic(fmt.Errorf("nbt: Unhandled list element type: %v", v.Type().Elem()))
+func (e *encodeState) writeList(v reflect.Value) {
+	elem := v.Type().Elem()
+	if isTreeType(elem) {
+		e.writeTreeList(v)
+		return
+	}
+	if elem.Implements(marshalerType) || reflect.PtrTo(elem).Implements(marshalerType) {
+		e.writeMarshalerList(v)
+		return
+	}
+
+	c := encoderFor(elem)
+	if c.tag == TAG_End {
+		panic(unhandledType(elem, "nbt: Unhandled list element type: %v", elem))
+	}
+	e.writeByte(byte(c.tag))
+	e.writeLength(v.Len())
+	if elem.Kind() == reflect.Uint8 && (v.Kind() == reflect.Slice || v.CanAddr()) {
+		e.write(v.Bytes())
+		return
 	}
-	w(out, tag)
-	w(out, uint32(v.Len()))
 
 	var i int
 	defer func() {
 		if r := recover(); r != nil {
-			panic(fmt.Errorf("%v\n\t\tat list index %d", r, i))
+			panicAt(r, indexSegment(i))
 		}
 	}()
 	for i = 0; i < v.Len(); i++ {
-		if mustConvertBool {
-			if v.Index(i).Bool() {
-				writeValue(out, TAG_Byte, uint8(1))
-			} else {
-				writeValue(out, TAG_Byte, uint8(0))
-			}
-		} else if tag == TAG_Compound {
-			if mustConvertMap {
-				writeMap(out, v.Index(i))
-			} else {
-				writeCompound(out, reflect.Indirect(v.Index(i)))
+		c.encode(e, v.Index(i))
+	}
+}
+
+func (e *encodeState) writeMap(v reflect.Value) {
+	var name string
+	defer func() {
+		if r := recover(); r != nil {
+			panicAt(r, fieldSegment(name))
+		}
+	}()
+
+	for _, key := range v.MapKeys() {
+		name = key.String()
+		if value := v.MapIndex(key); !isNil(value) {
+			e.writeTag(name, reflect.Indirect(value))
+		}
+	}
+	e.writeByte(byte(TAG_End))
+}
+
+func (e *encodeState) writeCompound(v reflect.Value) {
+	v = reflect.Indirect(v)
+	info := getStructInfo(v.Type())
+
+	var name string
+	defer func() {
+		if r := recover(); r != nil {
+			panicAt(r, fieldSegment(name))
+		}
+	}()
+
+	for _, f := range info.fields {
+		name = f.name
+
+		value, ok := lookupField(v, f.index)
+		if !ok || isNil(value) || (f.omitEmpty && isEmpty(value)) {
+			continue
+		}
+		if f.as != TAG_End {
+			e.writeTagAs(name, value, f.as, f.elem)
+		} else {
+			e.writeTag(name, value)
+		}
+	}
+
+	if rest, ok := restField(v); ok {
+		if rest.Type() == compoundType || rest.Type() == reflect.PtrTo(compoundType) {
+			value, _ := treeValue(rest)
+			c := value.(*Compound)
+			for _, n := range c.Names() {
+				if _, ok := info.byName[n]; !ok {
+					e.writeTreeTag(n, c.Get(n))
+				}
 			}
-		} else if tag == TAG_List {
-			writeList(out, v.Index(i))
-		} else if tag == TAG_Byte_Array {
-			writeValue(out, tag, v.Index(i).Bytes())
-		} else if tag == TAG_Int_Array {
-			for j := 0; j < v.Index(i).Len(); j++ {
-				writeValue(out, TAG_Int, v.Index(i).Index(j).Interface())
+		} else if rest.Kind() == reflect.Map {
+			for _, key := range rest.MapKeys() {
+				name = key.String()
+				if _, ok := info.byName[name]; ok {
+					continue
+				}
+				if value := rest.MapIndex(key); !isNil(value) {
+					e.writeTag(name, reflect.Indirect(value))
+				}
 			}
 		} else {
-			writeValue(out, tag, v.Index(i).Interface())
+			panic(unhandledType(rest.Type(), "nbt: The rest field must be a map with string keys or a *Compound, not a %v", rest.Type()))
 		}
 	}
+	e.writeByte(byte(TAG_End))
 }
 
-func writeMap(out io.Writer, v reflect.Value) {
-	for _, name := range v.MapKeys() {
-		writeTag(out, name.String(), reflect.Indirect(v.MapIndex(name)))
+// Writes a struct field with an explicit tag type. For lists, elem is the element tag, or TAG_End
+// to use the default for the element type.
+func (e *encodeState) writeTagAs(name string, v reflect.Value, tag, elem Tag) {
+	v = reflect.Indirect(v)
+	for v.Kind() == reflect.Interface {
+		v = reflect.Indirect(v.Elem())
+	}
+
+	switch tag {
+	case TAG_Byte_Array, TAG_Int_Array, TAG_Long_Array, TAG_List:
+		if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+			panic(unhandledType(v.Type(), "nbt: Cannot encode a %v as a %s", v.Type(), tag))
+		}
+	}
+
+	e.writeHeader(tag, name)
+
+	switch tag {
+	case TAG_Byte_Array, TAG_Int_Array, TAG_Long_Array:
+		elem = arrayElem(tag)
+		e.writeLength(v.Len())
+		for i := 0; i < v.Len(); i++ {
+			e.writeNumber(elem, v.Index(i))
+		}
+
+	case TAG_List:
+		if elem == TAG_End {
+			e.writeList(v)
+			return
+		}
+
+		e.writeByte(byte(elem))
+		e.writeLength(v.Len())
+
+		var i int
+		defer func() {
+			if r := recover(); r != nil {
+				panicAt(r, indexSegment(i))
+			}
+		}()
+		for i = 0; i < v.Len(); i++ {
+			e.writeNumber(elem, v.Index(i))
+		}
+
+	default:
+		e.writeNumber(tag, v)
 	}
-	w(out, TAG_End)
 }
 
-func writeCompound(out io.Writer, v reflect.Value) {
-	v = reflect.Indirect(v)
-	fields := parseStruct(v)
+// Writes a number or bool as the payload of a numeric tag, checking that it fits.
+func (e *encodeState) writeNumber(tag Tag, v reflect.Value) {
+	var i int64
+	var u uint64
+	var f float64
+	var signed, isFloat bool
 
-	for name, value := range fields {
-		writeTag(out, name, value)
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			u = 1
+		}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, signed = v.Int(), true
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u = v.Uint()
+	case reflect.Float32, reflect.Float64:
+		f, isFloat = v.Float(), true
+	default:
+		panic(unhandledType(v.Type(), "nbt: Cannot encode a %v as a %s", v.Type(), tag))
+	}
+
+	switch tag {
+	case TAG_Float, TAG_Double:
+		if signed {
+			f = float64(i)
+		} else if !isFloat {
+			f = float64(u)
+		}
+		e.writeFloat(tag, f)
+		return
+	}
+
+	if isFloat {
+		panic(unhandledType(v.Type(), "nbt: Cannot encode a %v as a %s", v.Type(), tag))
+	}
+
+	// As for the default tags, unsigned values may use the sign bit.
+	if bits := uint(fixedSize(tag) * 8); bits < 64 {
+		if signed && (i < -1<<(bits-1) || i >= 1<<(bits-1)) {
+			panic(unhandledType(v.Type(), "nbt: Value %d overflows a %s", i, tag))
+		}
+		if !signed && u >= 1<<bits {
+			panic(unhandledType(v.Type(), "nbt: Value %d overflows a %s", u, tag))
+		}
+	}
+	if signed {
+		u = uint64(i)
+	}
+
+	switch tag {
+	case TAG_Byte, TAG_Short, TAG_Int, TAG_Long:
+		e.writeInt(tag, u)
+	default:
+		panic(unhandledType(v.Type(), "nbt: Cannot encode a %v as a %s", v.Type(), tag))
 	}
-	w(out, TAG_End)
 }
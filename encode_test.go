@@ -2,6 +2,7 @@ package nbt
 
 import (
 	"bytes"
+	"errors"
 	"io/ioutil"
 	"reflect"
 	"testing"
@@ -101,3 +102,384 @@ func TestEncode(t *testing.T) {
 		}
 	}
 }
+
+func TestEncodeArrays(t *testing.T) {
+	var encoded bytes.Buffer
+	err := Marshal(Uncompressed, &encoded, [][2]int64{{1, -1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte{
+		byte(TAG_List), 0, 0, byte(TAG_Long_Array), 0, 0, 0, 1,
+		0, 0, 0, 2,
+		0, 0, 0, 0, 0, 0, 0, 1,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+	}
+	if !bytes.Equal(encoded.Bytes(), expected) {
+		t.Errorf("Encoded % x, but expected % x", encoded.Bytes(), expected)
+	}
+
+	encoded.Reset()
+	err = Marshal(Uncompressed, &encoded, [2]int32{7, -7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = []byte{
+		byte(TAG_Int_Array), 0, 0,
+		0, 0, 0, 2,
+		0, 0, 0, 7,
+		0xff, 0xff, 0xff, 0xf9,
+	}
+	if !bytes.Equal(encoded.Bytes(), expected) {
+		t.Errorf("Encoded % x, but expected % x", encoded.Bytes(), expected)
+	}
+}
+
+type Unencodable struct {
+	Servers []struct {
+		Name string
+		Port complex64
+	}
+}
+
+func TestEncodeError(t *testing.T) {
+	var v Unencodable
+	v.Servers = make([]struct {
+		Name string
+		Port complex64
+	}, 2)
+
+	err := Marshal(Uncompressed, ioutil.Discard, v)
+	e, ok := err.(*EncodeError)
+	if !ok {
+		t.Fatalf("Error is a %T, but expected *EncodeError", err)
+	}
+
+	expected := []PathSegment{{Name: "Servers", Index: -1}, {Index: 0}, {Name: "Port", Index: -1}}
+	if !reflect.DeepEqual(e.Path, expected) {
+		t.Errorf("Path == %v, but expected %v", e.Path, expected)
+	}
+	if e.Type != reflect.TypeOf(complex64(0)) {
+		t.Errorf("Type == %v", e.Type)
+	}
+	if err.Error() != "nbt: Unhandled type: complex64 ((0+0i))\n\t\tat struct field \"Port\"\n\t\tat list index 0\n\t\tat struct field \"Servers\"" {
+		t.Error(err)
+	}
+}
+
+type failingWriter struct{ err error }
+
+func (w failingWriter) Write(p []byte) (int, error) { return 0, w.err }
+
+func TestEncodeErrorUnwrap(t *testing.T) {
+	errFull := errors.New("disk full")
+	err := Marshal(Uncompressed, failingWriter{errFull}, int32(1))
+	if _, ok := err.(*EncodeError); !ok {
+		t.Fatalf("Error is a %T, but expected *EncodeError", err)
+	}
+	if !errors.Is(err, errFull) {
+		t.Errorf("errors.Is(%v, errFull) is false", err)
+	}
+}
+
+type OptionalItem struct {
+	ID     string   `nbt:"id"`
+	Count  int8     `nbt:"Count,omitempty"`
+	Damage int16    `nbt:",omitempty"`
+	Tag    *ItemTag `nbt:"tag"`
+	Extra  map[string]interface{}
+	Custom interface{}
+}
+
+type ItemTag struct {
+	Unbreakable bool `nbt:",omitempty"`
+	Name        string
+}
+
+func TestEncodeOmitted(t *testing.T) {
+	for _, test := range []struct {
+		item  OptionalItem
+		names []string
+	}{
+		{OptionalItem{ID: "minecraft:stone"}, []string{"id"}},
+		{OptionalItem{ID: "minecraft:stone", Count: 64, Damage: 3}, []string{"id", "Count", "Damage"}},
+		{OptionalItem{Tag: &ItemTag{}, Extra: map[string]interface{}{"a": nil}, Custom: int32(1)}, []string{"id", "tag", "Extra", "Custom"}},
+	} {
+		var encoded bytes.Buffer
+		if err := Marshal(Uncompressed, &encoded, test.item); err != nil {
+			t.Error(err)
+			continue
+		}
+
+		var decoded *Compound
+		if err := Unmarshal(Uncompressed, &encoded, &decoded); err != nil {
+			t.Error(err)
+			continue
+		}
+		if names := decoded.Names(); !reflect.DeepEqual(names, test.names) {
+			t.Errorf("Encoded %+v with entries %v, but expected %v", test.item, names, test.names)
+		}
+		if tag, ok := decoded.GetCompound("tag"); ok {
+			if names := tag.Names(); !reflect.DeepEqual(names, []string{"Name"}) {
+				t.Errorf("Encoded tag with entries %v", names)
+			}
+		}
+		if extra, ok := decoded.GetCompound("Extra"); ok && extra.Len() != 0 {
+			t.Errorf("Encoded nil map entries: %v", extra.Names())
+		}
+	}
+}
+
+type Overrides struct {
+	Blocks    []byte   `nbt:",bytearray"`
+	Heights   []uint32 `nbt:"Heights,intarray"`
+	States    []int64  `nbt:",longarray"`
+	Motion    [3]int32 `nbt:",list"`
+	Health    int32    `nbt:",short"`
+	OnGround  bool     `nbt:",short"`
+	Rotation  []int16  `nbt:",float"`
+	Timestamp uint16   `nbt:",byte"`
+}
+
+func TestEncodeOverrides(t *testing.T) {
+	in := Overrides{
+		Blocks:    []byte{1, 2, 3},
+		Heights:   []uint32{0xffffffff, 64},
+		States:    []int64{-1},
+		Motion:    [3]int32{1, 2, 3},
+		Health:    -20,
+		OnGround:  true,
+		Rotation:  []int16{90, -45},
+		Timestamp: 200,
+	}
+
+	var encoded bytes.Buffer
+	if err := Marshal(Uncompressed, &encoded, in); err != nil {
+		t.Fatal(err)
+	}
+	data := encoded.Bytes()
+
+	var tree *Compound
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &tree); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]Tag{
+		"Blocks":    TAG_Byte_Array,
+		"Heights":   TAG_Int_Array,
+		"States":    TAG_Long_Array,
+		"Motion":    TAG_List,
+		"Health":    TAG_Short,
+		"OnGround":  TAG_Short,
+		"Rotation":  TAG_List,
+		"Timestamp": TAG_Byte,
+	}
+	for name, tag := range expected {
+		if v := tree.Get(name); v == nil || v.Tag() != tag {
+			t.Errorf("%s was encoded as %v, but expected %s", name, v, tag)
+		}
+	}
+	if rotation, _ := tree.GetList("Rotation"); rotation.Elem != TAG_Float || rotation.Get(1) != Float(-45) {
+		t.Errorf("Rotation == %#v", rotation)
+	}
+	if timestamp, _ := tree.GetByte("Timestamp"); timestamp != -56 {
+		t.Errorf("Timestamp == %d", timestamp)
+	}
+
+	var out Overrides
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("Decoded %#v, but expected %#v", out, in)
+	}
+
+	err := Marshal(Uncompressed, ioutil.Discard, struct {
+		Health int32 `nbt:",byte"`
+	}{1000})
+	if err == nil {
+		t.Error("No error, but one was expected!")
+	} else if err.Error() != "nbt: Value 1000 overflows a TAG_Byte (0x01)\n\t\tat struct field \"Health\"" {
+		t.Error(err)
+	}
+}
+
+func TestEncodeOverrideOptions(t *testing.T) {
+	// The element tag wins whatever the order of the options, and byte arrays in a struct passed
+	// by value can be written as lists.
+	in := struct {
+		A []int32 `nbt:",short,list"`
+		B []int32 `nbt:",list,short"`
+		C [2]byte `nbt:",list"`
+	}{[]int32{1}, []int32{2}, [2]byte{3, 4}}
+
+	var encoded bytes.Buffer
+	if err := Marshal(Uncompressed, &encoded, in); err != nil {
+		t.Fatal(err)
+	}
+	var tree *Compound
+	if err := Unmarshal(Uncompressed, &encoded, &tree); err != nil {
+		t.Fatal(err)
+	}
+	for name, elem := range map[string]Tag{"A": TAG_Short, "B": TAG_Short, "C": TAG_Byte} {
+		if list, ok := tree.GetList(name); !ok || list.Elem != elem || list.Len() == 0 {
+			t.Errorf("%s was encoded as %#v, but expected a list of %s", name, tree.Get(name), elem)
+		}
+	}
+}
+
+type ConvertedInner struct {
+	N int16
+}
+
+func TestDecodeOverrideConversion(t *testing.T) {
+	// A list of compounds holding a TAG_Byte "N".
+	data := []byte{10, 0, 0, 9, 0, 1, 'L', 10, 0, 0, 0, 1, 1, 0, 1, 'N', 5, 0, 0}
+
+	// The option converts the elements of L, but not what is inside them.
+	var overridden struct {
+		L []ConvertedInner `nbt:",list"`
+	}
+	var plain struct {
+		L []ConvertedInner
+	}
+	for _, v := range []interface{}{&overridden, &plain} {
+		if err := Unmarshal(Uncompressed, bytes.NewReader(data), v); err == nil {
+			t.Errorf("A TAG_Byte was decoded into an int16 in %T", v)
+		}
+	}
+
+	var converted struct {
+		L []int16 `nbt:",list"`
+	}
+	data = []byte{10, 0, 0, 9, 0, 1, 'L', 1, 0, 0, 0, 1, 5, 0}
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &converted); err != nil {
+		t.Error(err)
+	} else if len(converted.L) != 1 || converted.L[0] != 5 {
+		t.Errorf("Decoded %#v", converted)
+	}
+}
+
+type Entity struct {
+	Pos      [3]float64 `nbt:",list"`
+	Motion   [3]float64 `nbt:",list"`
+	Rotation [2]float32 `nbt:",list"`
+	Health   float32
+}
+
+type Equipment struct {
+	HandItems []string
+	Health    int16 // Hidden, like Entity.Health, by the shallower Creeper.Health.
+}
+
+type Creeper struct {
+	Entity
+	*Equipment
+
+	Fuse   int16
+	Health float32
+}
+
+type Villager struct {
+	Entity `nbt:"Entity"` // A name keeps the embedded struct in a compound of its own.
+
+	Profession string
+}
+
+func TestEncodeEmbedded(t *testing.T) {
+	in := Creeper{
+		Entity: Entity{
+			Pos:    [3]float64{1, 64, -3},
+			Health: 10,
+		},
+		Equipment: &Equipment{HandItems: []string{"minecraft:tnt"}, Health: 7},
+		Fuse:      30,
+		Health:    20,
+	}
+
+	var encoded bytes.Buffer
+	if err := Marshal(Uncompressed, &encoded, in); err != nil {
+		t.Fatal(err)
+	}
+
+	var tree *Compound
+	if err := Unmarshal(Uncompressed, bytes.NewReader(encoded.Bytes()), &tree); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"Pos", "Motion", "Rotation", "HandItems", "Fuse", "Health"}
+	if names := tree.Names(); !reflect.DeepEqual(names, expected) {
+		t.Errorf("Encoded %v, but expected %v", names, expected)
+	}
+	if health, _ := tree.GetFloat("Health"); health != 20 {
+		t.Errorf("Health == %v", health)
+	}
+
+	var out Creeper
+	if err := Unmarshal(Uncompressed, bytes.NewReader(encoded.Bytes()), &out); err != nil {
+		t.Fatal(err)
+	}
+	in.Entity.Health, in.Equipment.Health = 0, 0
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("Decoded %#v, but expected %#v", out, in)
+	}
+
+	encoded.Reset()
+	if err := Marshal(Uncompressed, &encoded, Creeper{Fuse: 30}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Unmarshal(Uncompressed, bytes.NewReader(encoded.Bytes()), &tree); err != nil {
+		t.Fatal(err)
+	}
+	if tree.Get("HandItems") != nil {
+		t.Errorf("A nil embedded pointer was encoded: %v", tree.Names())
+	}
+
+	encoded.Reset()
+	if err := Marshal(Uncompressed, &encoded, Villager{Entity: Entity{Health: 20}, Profession: "minecraft:farmer"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Unmarshal(Uncompressed, bytes.NewReader(encoded.Bytes()), &tree); err != nil {
+		t.Fatal(err)
+	}
+	if entity, _ := tree.GetCompound("Entity"); entity == nil || entity.Get("Health") != Float(20) {
+		t.Errorf("Encoded %v, but expected a nested Entity compound", tree.Names())
+	}
+}
+
+type SharedPos struct {
+	X int32 `nbt:"X"`
+}
+
+type SharedBlock struct {
+	SharedPos
+	Block string
+}
+
+type SharedEntity struct {
+	SharedPos
+	Entity string
+}
+
+// SharedPos is embedded twice at the same depth, so its X conflicts with itself and is dropped,
+// as encoding/json does.
+type SharedBoth struct {
+	SharedBlock
+	SharedEntity
+}
+
+func TestEncodeEmbeddedTwice(t *testing.T) {
+	var encoded bytes.Buffer
+	in := SharedBoth{SharedBlock{SharedPos{1}, "minecraft:chest"}, SharedEntity{SharedPos{2}, "minecraft:pig"}}
+	if err := Marshal(Uncompressed, &encoded, in); err != nil {
+		t.Fatal(err)
+	}
+
+	var tree *Compound
+	if err := Unmarshal(Uncompressed, &encoded, &tree); err != nil {
+		t.Fatal(err)
+	}
+	if names, expected := tree.Names(), []string{"Block", "Entity"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Encoded %v, but expected %v", names, expected)
+	}
+}
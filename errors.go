@@ -0,0 +1,190 @@
+package nbt
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// A PathSegment is one step on the way from the root tag to a value: either a compound entry or
+// a list element.
+type PathSegment struct {
+	Name  string // The name of the compound entry, if Index is negative.
+	Index int    // The index of the list element, or -1 for a compound entry.
+}
+
+func fieldSegment(name string) PathSegment {
+	return PathSegment{Name: name, Index: -1}
+}
+
+func indexSegment(i int) PathSegment {
+	return PathSegment{Index: i}
+}
+
+func (s PathSegment) String() string {
+	if s.Index < 0 {
+		return fmt.Sprintf("struct field %#v", s.Name)
+	}
+	return fmt.Sprintf("list index %d", s.Index)
+}
+
+// formatPath writes the path innermost first, one segment per line, after the message.
+func formatPath(msg string, path []PathSegment) string {
+	for i := len(path) - 1; i >= 0; i-- {
+		msg += "\n\t\tat " + path[i].String()
+	}
+	return msg
+}
+
+// A DecodeError is returned by Unmarshal and Decoder.Decode. Its fields describe where and why
+// decoding failed without parsing the message.
+type DecodeError struct {
+	Msg  string        // The message, without the path.
+	Path []PathSegment // From the root tag to the value that failed.
+
+	Expected Tag          // The tag the Go type would be encoded as, or TAG_End if unknown.
+	Actual   Tag          // The tag found in the input, or TAG_End if unknown.
+	Type     reflect.Type // The Go type the value was being decoded into, if any.
+
+	Offset int64 // The number of bytes of decompressed input read before the error.
+	Err    error // The underlying error, such as io.ErrUnexpectedEOF or one returned by an Unmarshaler.
+}
+
+func (e *DecodeError) Error() string {
+	return formatPath(e.Msg, e.Path)
+}
+
+// Returns the underlying error, so that errors.Is and errors.As see it.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// An EncodeError is returned by Marshal and Encoder.Encode.
+type EncodeError struct {
+	Msg  string        // The message, without the path.
+	Path []PathSegment // From the root tag to the value that failed.
+
+	Type reflect.Type // The Go type of the value that failed, if any.
+	Err  error        // The underlying error, such as a write error or one returned by a Marshaler.
+}
+
+func (e *EncodeError) Error() string {
+	return formatPath(e.Msg, e.Path)
+}
+
+// Returns the underlying error, so that errors.Is and errors.As see it.
+func (e *EncodeError) Unwrap() error {
+	return e.Err
+}
+
+// Converts a recovered panic into a *DecodeError. New errors get the offset they happened at, and
+// the end of the input partway through a tag is reported as io.ErrUnexpectedEOF.
+func decodeError(r interface{}, offset int64) *DecodeError {
+	if r == io.EOF {
+		r = io.ErrUnexpectedEOF
+	}
+	switch r := r.(type) {
+	case *DecodeError:
+		return r
+	case error:
+		return &DecodeError{Msg: r.Error(), Offset: offset, Err: r}
+	case string:
+		return &DecodeError{Msg: r, Offset: offset}
+	}
+	panic(r)
+}
+
+// Adds a path segment to a recovered panic and continues panicking.
+func decodePanicAt(r interface{}, offset int64, s PathSegment) {
+	err := decodeError(r, offset)
+	err.Path = append([]PathSegment{s}, err.Path...)
+	panic(err)
+}
+
+func (d *decodeState) error(r interface{}) *DecodeError {
+	return decodeError(r, d.offset)
+}
+
+func (d *decodeState) panicAt(r interface{}, s PathSegment) {
+	decodePanicAt(r, d.offset, s)
+}
+
+func (d *decodeState) errorf(format string, args ...interface{}) *DecodeError {
+	return &DecodeError{Msg: fmt.Sprintf(format, args...), Offset: d.offset}
+}
+
+// Returns an error saying that a tag cannot be stored in v.
+func (d *decodeState) typeError(tag Tag, v reflect.Value) *DecodeError {
+	return &DecodeError{
+		Msg:      fmt.Sprintf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()),
+		Expected: tagFor(v.Type()),
+		Actual:   tag,
+		Type:     v.Type(),
+		Offset:   d.offset,
+	}
+}
+
+// Converts a recovered panic into an *EncodeError.
+func encodeError(r interface{}) *EncodeError {
+	switch r := r.(type) {
+	case *EncodeError:
+		return r
+	case error:
+		return &EncodeError{Msg: r.Error(), Err: r}
+	case string:
+		return &EncodeError{Msg: r}
+	}
+	panic(r)
+}
+
+// Adds a path segment to a recovered panic and continues panicking.
+func panicAt(r interface{}, s PathSegment) {
+	err := encodeError(r)
+	err.Path = append([]PathSegment{s}, err.Path...)
+	panic(err)
+}
+
+// Returns an error saying that values of type t cannot be encoded.
+func unhandledType(t reflect.Type, format string, args ...interface{}) *EncodeError {
+	return &EncodeError{Msg: fmt.Sprintf(format, args...), Type: t}
+}
+
+// Returns the tag a value of type t is encoded as, or TAG_End if it can't be encoded.
+func tagFor(t reflect.Type) Tag {
+	if t.Kind() != reflect.Interface && t.Implements(valueType) {
+		return reflect.Zero(t).Interface().(Value).Tag()
+	}
+
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int8, reflect.Uint8:
+		return TAG_Byte
+	case reflect.Int16, reflect.Uint16:
+		return TAG_Short
+	case reflect.Int32, reflect.Uint32:
+		return TAG_Int
+	case reflect.Int64, reflect.Uint64:
+		return TAG_Long
+	case reflect.Float32:
+		return TAG_Float
+	case reflect.Float64:
+		return TAG_Double
+	case reflect.String:
+		return TAG_String
+	case reflect.Array:
+		switch t.Elem().Kind() {
+		case reflect.Uint8:
+			return TAG_Byte_Array
+		case reflect.Int32, reflect.Uint32:
+			return TAG_Int_Array
+		case reflect.Int64, reflect.Uint64:
+			return TAG_Long_Array
+		}
+	case reflect.Slice:
+		return TAG_List
+	case reflect.Map, reflect.Struct:
+		return TAG_Compound
+	case reflect.Ptr:
+		return tagFor(t.Elem())
+	}
+	return TAG_End
+}
@@ -0,0 +1,562 @@
+package nbt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Typed JSON is a form of NBT that keeps every tag type, so NBT converted to typed JSON and back
+// is the same, byte for byte. A root tag is a JSON object with its name, type and value:
+//
+//	{"name": "hello world", "type": "compound", "value": [
+//		{"name": "name", "type": "string", "value": "Bananrama"},
+//		{"name": "pos", "type": "list", "value": {"elem": "double", "values": [0, 64.5, 0]}}
+//	]}
+//
+// The types are the tag names in lower case without the TAG_ prefix, and their values are:
+//
+//	byte, short, int       a number
+//	long                   a string of decimal digits, as JSON numbers lose precision past 2^53
+//	float, double          a number, or one of the strings "NaN", "Infinity" and "-Infinity"; a NaN
+//	                       other than the one Java writes is "NaN:0x" followed by its bits in hex
+//	string                 a string
+//	byte_array, int_array  an array of numbers; bytes are signed, from -128 to 127
+//	long_array             an array of strings of decimal digits
+//	list                   {"elem": type, "values": [value...]}; an empty list may have the type "end"
+//	compound               an array of {"name": name, "type": type, "value": value} entries, in order
+//
+// Names and strings that are not valid UTF-8, such as the modified UTF-8 Java writes for some
+// characters, are written as arrays of their bytes instead, since JSON strings can't hold them.
+// Longs may also be given as numbers and integers as strings of digits.
+
+// The bits of the NaNs Java writes. Float.floatToIntBits and Double.doubleToLongBits turn every
+// NaN into these.
+const (
+	javaFloatNaN  = 0x7fc00000
+	javaDoubleNaN = 0x7ff8000000000000
+)
+
+var jsonTypes = [...]string{
+	TAG_End:        "end",
+	TAG_Byte:       "byte",
+	TAG_Short:      "short",
+	TAG_Int:        "int",
+	TAG_Long:       "long",
+	TAG_Float:      "float",
+	TAG_Double:     "double",
+	TAG_Byte_Array: "byte_array",
+	TAG_String:     "string",
+	TAG_List:       "list",
+	TAG_Compound:   "compound",
+	TAG_Int_Array:  "int_array",
+	TAG_Long_Array: "long_array",
+}
+
+// A TypedJSON is a root tag, with its name, in the typed JSON form. Use it with encoding/json.
+type TypedJSON struct {
+	Name  string
+	Value Value
+}
+
+// A tag in typed JSON: a root tag or a compound entry.
+type jsonTag struct {
+	Name  interface{} `json:"name"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+type jsonList struct {
+	Elem   string        `json:"elem"`
+	Values []interface{} `json:"values"`
+}
+
+type rawJSONTag struct {
+	Name  json.RawMessage `json:"name"`
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+type rawJSONList struct {
+	Elem   string            `json:"elem"`
+	Values []json.RawMessage `json:"values"`
+}
+
+// Reads one NBT root tag from in and writes it to out as typed JSON, followed by a newline.
+func NBTToJSON(compression Compression, in io.Reader, out io.Writer) error {
+	var t TypedJSON
+	name, err := UnmarshalNamed(compression, in, &t.Value)
+	if err != nil {
+		return err
+	}
+	t.Name = name
+
+	tag, err := t.tag()
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(out)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(tag)
+}
+
+// Reads a root tag in typed JSON from in and writes it to out as NBT. Anything but white space
+// after the root tag is an error.
+func JSONToNBT(in io.Reader, compression Compression, out io.Writer) error {
+	var t TypedJSON
+	dec := json.NewDecoder(in)
+	if err := dec.Decode(&t); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return &DecodeError{Msg: "nbt: Expected the end of the typed JSON", Offset: dec.InputOffset()}
+	}
+	return MarshalNamed(compression, out, t.Name, t.Value)
+}
+
+func (t TypedJSON) MarshalJSON() ([]byte, error) {
+	tag, err := t.tag()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(tag)
+}
+
+func (t TypedJSON) tag() (tag jsonTag, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = encodeError(r)
+		}
+	}()
+
+	if t.Value == nil {
+		panic(fmt.Errorf("nbt: Unhandled nil value"))
+	}
+	return jsonTag{Name: jsonText(t.Name), Type: jsonTypes[t.Value.Tag()], Value: jsonPayload(t.Value)}, nil
+}
+
+// Returns the typed JSON for the payload of v, ready for json.Marshal.
+func jsonPayload(v Value) interface{} {
+	switch v := v.(type) {
+	case Byte, Short, Int:
+		return v
+	case Long:
+		return strconv.FormatInt(int64(v), 10)
+	case Float:
+		if v != v {
+			return jsonNaN(uint64(math.Float32bits(float32(v))), javaFloatNaN)
+		}
+		return jsonFloat(float64(v), float32(v))
+	case Double:
+		if v != v {
+			return jsonNaN(math.Float64bits(float64(v)), javaDoubleNaN)
+		}
+		return jsonFloat(float64(v), float64(v))
+	case String:
+		return jsonText(string(v))
+
+	case ByteArray:
+		values := make([]int8, len(v))
+		for i, b := range v {
+			values[i] = int8(b)
+		}
+		return values
+	case IntArray:
+		if v == nil {
+			return []int32{}
+		}
+		return []int32(v)
+	case LongArray:
+		values := make([]string, len(v))
+		for i, n := range v {
+			values[i] = strconv.FormatInt(n, 10)
+		}
+		return values
+
+	case *List:
+		if v == nil {
+			panic(fmt.Errorf("nbt: Unhandled nil value"))
+		}
+		list := jsonList{Elem: jsonTypes[v.Elem], Values: make([]interface{}, len(v.Values))}
+		for i, elem := range v.Values {
+			if elem.Tag() != v.Elem {
+				panicAt(fmt.Errorf("nbt: Cannot put a %s in a list of %s", elem.Tag(), v.Elem), indexSegment(i))
+			}
+			list.Values[i] = jsonPayload(elem)
+		}
+		return list
+
+	case *Compound:
+		if v == nil {
+			panic(fmt.Errorf("nbt: Unhandled nil value"))
+		}
+		names := v.Names()
+		entries := make([]jsonTag, len(names))
+		for i, name := range names {
+			elem := v.Get(name)
+			entries[i] = jsonTag{Name: jsonText(name), Type: jsonTypes[elem.Tag()], Value: jsonPayload(elem)}
+		}
+		return entries
+	}
+	panic(fmt.Errorf("nbt: Unhandled type: %T", v))
+}
+
+// Returns f, or the name of f if it has no JSON number.
+func jsonFloat(f float64, v interface{}) interface{} {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+	return v
+}
+
+// Returns the name of a NaN in typed JSON. Any NaN other than nan, the one Java writes, is named
+// after its bits, so that its payload is kept.
+func jsonNaN(bits, nan uint64) string {
+	if bits == nan {
+		return "NaN"
+	}
+	return fmt.Sprintf("NaN:0x%x", bits)
+}
+
+// Returns s, or its bytes if it is not valid UTF-8.
+func jsonText(s string) interface{} {
+	if utf8.ValidString(s) {
+		return s
+	}
+	values := make([]int, len(s))
+	for i := range values {
+		values[i] = int(s[i])
+	}
+	return values
+}
+
+func (t *TypedJSON) UnmarshalJSON(data []byte) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = decodeError(r, 0)
+		}
+	}()
+
+	var tag rawJSONTag
+	decodeJSON(data, &tag)
+	t.Name = parseJSONText(tag.Name)
+	t.Value = parseJSONPayload(parseJSONType(tag.Type), tag.Value)
+	return nil
+}
+
+func decodeJSON(data []byte, v interface{}) {
+	if len(data) == 0 {
+		panic(fmt.Errorf("nbt: Missing value in typed JSON"))
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		panic(&DecodeError{Msg: "nbt: Invalid typed JSON: " + err.Error(), Err: err})
+	}
+}
+
+func parseJSONType(name string) Tag {
+	for tag, typeName := range jsonTypes {
+		if typeName == name && Tag(tag) != TAG_End {
+			return Tag(tag)
+		}
+	}
+	panic(fmt.Errorf("nbt: Unknown type %q in typed JSON", name))
+}
+
+// Reads a name or string, given as a JSON string or an array of bytes.
+func parseJSONText(data []byte) string {
+	if len(data) > 0 && data[0] == '[' {
+		var b []int
+		decodeJSON(data, &b)
+		s := make([]byte, len(b))
+		for i, n := range b {
+			if n < 0 || n > 255 {
+				panic(fmt.Errorf("nbt: Invalid byte in typed JSON: %d", n))
+			}
+			s[i] = byte(n)
+		}
+		return string(s)
+	}
+	var s string
+	decodeJSON(data, &s)
+	return s
+}
+
+// Returns the text of a number, given as a JSON number or a string.
+func parseJSONNumber(data []byte) string {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		decodeJSON(data, &s)
+		return s
+	}
+	var n json.Number
+	decodeJSON(data, &n)
+	return string(n)
+}
+
+func parseJSONInt(data []byte, tag Tag, bits int) int64 {
+	s := parseJSONNumber(data)
+	n, err := strconv.ParseInt(s, 10, bits)
+	if err != nil {
+		panic(fmt.Errorf("nbt: Invalid %s in typed JSON: %s", tag, s))
+	}
+	return n
+}
+
+// Reads a float or double. NaNs are made from their bits, which converting a NaN between float64
+// and float32 might not keep.
+func parseJSONFloat(data []byte, tag Tag) Value {
+	s := parseJSONNumber(data)
+	size, nan := 64, uint64(javaDoubleNaN)
+	if tag == TAG_Float {
+		size, nan = 32, javaFloatNaN
+	}
+
+	var f float64
+	switch {
+	case s == "NaN":
+		return floatFromBits(tag, nan)
+	case strings.HasPrefix(s, "NaN:0x"):
+		bits, err := strconv.ParseUint(s[len("NaN:0x"):], 16, size)
+		if err != nil || !isNaN(floatFromBits(tag, bits)) {
+			panic(fmt.Errorf("nbt: Invalid %s in typed JSON: %s", tag, s))
+		}
+		return floatFromBits(tag, bits)
+	case s == "Infinity":
+		f = math.Inf(1)
+	case s == "-Infinity":
+		f = math.Inf(-1)
+	default:
+		var err error
+		if f, err = strconv.ParseFloat(s, size); err != nil {
+			panic(fmt.Errorf("nbt: Invalid %s in typed JSON: %s", tag, s))
+		}
+	}
+	if tag == TAG_Float {
+		return Float(f)
+	}
+	return Double(f)
+}
+
+func floatFromBits(tag Tag, bits uint64) Value {
+	if tag == TAG_Float {
+		return Float(math.Float32frombits(uint32(bits)))
+	}
+	return Double(math.Float64frombits(bits))
+}
+
+func isNaN(v Value) bool {
+	switch v := v.(type) {
+	case Float:
+		return v != v
+	case Double:
+		return v != v
+	}
+	return false
+}
+
+// Reads the typed JSON for the payload of a tag into the matching tree type.
+func parseJSONPayload(tag Tag, data []byte) Value {
+	switch tag {
+	case TAG_Byte:
+		return Byte(parseJSONInt(data, tag, 8))
+	case TAG_Short:
+		return Short(parseJSONInt(data, tag, 16))
+	case TAG_Int:
+		return Int(parseJSONInt(data, tag, 32))
+	case TAG_Long:
+		return Long(parseJSONInt(data, tag, 64))
+	case TAG_Float, TAG_Double:
+		return parseJSONFloat(data, tag)
+	case TAG_String:
+		return String(parseJSONText(data))
+
+	case TAG_Byte_Array, TAG_Int_Array, TAG_Long_Array:
+		var elems []json.RawMessage
+		decodeJSON(data, &elems)
+		elem := arrayElem(tag)
+		values := make([]int64, len(elems))
+		for i := range elems {
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						decodePanicAt(r, 0, indexSegment(i))
+					}
+				}()
+				values[i] = parseJSONInt(elems[i], elem, 8*int(fixedSize(elem)))
+			}()
+		}
+		switch tag {
+		case TAG_Byte_Array:
+			array := make(ByteArray, len(values))
+			for i, n := range values {
+				array[i] = byte(n)
+			}
+			return array
+		case TAG_Int_Array:
+			array := make(IntArray, len(values))
+			for i, n := range values {
+				array[i] = int32(n)
+			}
+			return array
+		}
+		return LongArray(values)
+
+	case TAG_List:
+		var raw rawJSONList
+		decodeJSON(data, &raw)
+		list := &List{Values: make([]Value, len(raw.Values))}
+		if raw.Elem == "end" && len(raw.Values) == 0 {
+			return list
+		}
+		list.Elem = parseJSONType(raw.Elem)
+		for i := range raw.Values {
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						decodePanicAt(r, 0, indexSegment(i))
+					}
+				}()
+				list.Values[i] = parseJSONPayload(list.Elem, raw.Values[i])
+			}()
+		}
+		return list
+
+	case TAG_Compound:
+		var entries []rawJSONTag
+		decodeJSON(data, &entries)
+		c := new(Compound)
+		for _, entry := range entries {
+			name := parseJSONText(entry.Name)
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						decodePanicAt(r, 0, fieldSegment(name))
+					}
+				}()
+				c.Set(name, parseJSONPayload(parseJSONType(entry.Type), entry.Value))
+			}()
+		}
+		return c
+	}
+	panic(fmt.Errorf("nbt: Unhandled tag: %s", tag))
+}
+
+// Writes v, a tree value or any Go value Marshal accepts, as plain JSON for display: compounds
+// become objects with their entries in order, lists and arrays become arrays, and every number
+// becomes a JSON number, except that floats which are infinite or not a number become the strings
+// "Infinity", "-Infinity" and "NaN". Tag types are lost, as are bytes of names and strings that aren't valid UTF-8,
+// so plain JSON can't be turned back into the same NBT.
+func MarshalPlainJSON(v interface{}) (data []byte, err error) {
+	value, err := toTree(v)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = encodeError(r)
+		}
+	}()
+
+	var buf bytes.Buffer
+	writePlainJSON(&buf, value)
+	return buf.Bytes(), nil
+}
+
+func writePlainJSON(buf *bytes.Buffer, v Value) {
+	switch v := v.(type) {
+	case Byte:
+		buf.WriteString(strconv.FormatInt(int64(v), 10))
+	case Short:
+		buf.WriteString(strconv.FormatInt(int64(v), 10))
+	case Int:
+		buf.WriteString(strconv.FormatInt(int64(v), 10))
+	case Long:
+		buf.WriteString(strconv.FormatInt(int64(v), 10))
+	case Float:
+		writeJSONValue(buf, jsonFloat(float64(v), float32(v)))
+	case Double:
+		writeJSONValue(buf, jsonFloat(float64(v), float64(v)))
+	case String:
+		writeJSONValue(buf, string(v))
+
+	case ByteArray:
+		buf.WriteByte('[')
+		for i, b := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(strconv.FormatInt(int64(int8(b)), 10))
+		}
+		buf.WriteByte(']')
+	case IntArray:
+		buf.WriteByte('[')
+		for i, n := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(strconv.FormatInt(int64(n), 10))
+		}
+		buf.WriteByte(']')
+	case LongArray:
+		buf.WriteByte('[')
+		for i, n := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(strconv.FormatInt(n, 10))
+		}
+		buf.WriteByte(']')
+
+	case *List:
+		if v == nil {
+			panic(fmt.Errorf("nbt: Unhandled nil value"))
+		}
+		buf.WriteByte('[')
+		for i, elem := range v.Values {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writePlainJSON(buf, elem)
+		}
+		buf.WriteByte(']')
+
+	case *Compound:
+		if v == nil {
+			panic(fmt.Errorf("nbt: Unhandled nil value"))
+		}
+		buf.WriteByte('{')
+		for i, name := range v.Names() {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeJSONValue(buf, name)
+			buf.WriteByte(':')
+			writePlainJSON(buf, v.Get(name))
+		}
+		buf.WriteByte('}')
+
+	default:
+		panic(fmt.Errorf("nbt: Unhandled type: %T", v))
+	}
+}
+
+// Writes a single JSON value without escaping HTML characters, which only hurts readability.
+func writeJSONValue(buf *bytes.Buffer, v interface{}) {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		panic(err)
+	}
+	buf.Truncate(buf.Len() - 1) // Encode ends the value with a newline.
+}
@@ -0,0 +1,159 @@
+package nbt
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		compression Compression
+	}{
+		{"servers.dat", Uncompressed},
+		{"bigtest.nbt", GZip},
+		{"Nightgunner5.dat", GZip},
+		{"longarraytest.nbt", GZip},
+	} {
+		data := readTestcase(t, test.name, test.compression)
+
+		var typed bytes.Buffer
+		if err := NBTToJSON(Uncompressed, bytes.NewReader(data), &typed); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !json.Valid(typed.Bytes()) {
+			t.Errorf("%s: Invalid JSON: %s", test.name, typed.Bytes())
+			continue
+		}
+
+		var nbt bytes.Buffer
+		if err := JSONToNBT(&typed, Uncompressed, &nbt); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !bytes.Equal(nbt.Bytes(), data) {
+			t.Errorf("%s: JSON round trip differs from the original", test.name)
+		}
+	}
+}
+
+func TestTypedJSON(t *testing.T) {
+	root := new(Compound)
+	root.SetByte("b", -1)
+	root.SetLong("l", 1<<60)
+	root.SetFloat("f", float32(math.Inf(-1)))
+	root.SetDouble("d", 0.5)
+	root.SetFloat("nan", math.Float32frombits(0xffff3030))
+	root.SetDouble("javanan", math.Float64frombits(0x7ff8000000000000))
+	root.SetString("s", "<\xc0\x80>")
+	root.SetByteArray("ba", []byte{1, 0xff})
+	root.SetLongArray("la", []int64{-2})
+	root.SetList("empty", &List{})
+	root.SetList("ints", &List{Elem: TAG_Int, Values: []Value{Int(7)}})
+
+	data, err := json.Marshal(TypedJSON{Name: "root", Value: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"name":"root","type":"compound","value":[` +
+		`{"name":"b","type":"byte","value":-1},` +
+		`{"name":"l","type":"long","value":"1152921504606846976"},` +
+		`{"name":"f","type":"float","value":"-Infinity"},` +
+		`{"name":"d","type":"double","value":0.5},` +
+		`{"name":"nan","type":"float","value":"NaN:0xffff3030"},` +
+		`{"name":"javanan","type":"double","value":"NaN"},` +
+		`{"name":"s","type":"string","value":[60,192,128,62]},` +
+		`{"name":"ba","type":"byte_array","value":[1,-1]},` +
+		`{"name":"la","type":"long_array","value":["-2"]},` +
+		`{"name":"empty","type":"list","value":{"elem":"end","values":[]}},` +
+		`{"name":"ints","type":"list","value":{"elem":"int","values":[7]}}]}`
+	if string(data) != expected {
+		t.Errorf("Encoded %s, but expected %s", data, expected)
+	}
+
+	var decoded TypedJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Name != "root" || !bytes.Equal(encodeTree(t, decoded.Value), encodeTree(t, root)) {
+		t.Errorf("Decoded %#v", decoded)
+	}
+
+	// Hand-written JSON may give longs as numbers and integers as strings.
+	if err := json.Unmarshal([]byte(`{"name":"","type":"list","value":{"elem":"long","values":[1,"2"]}}`), &decoded); err != nil {
+		t.Error(err)
+	} else if list, ok := decoded.Value.(*List); !ok || list.Len() != 2 || list.Values[1] != Long(2) {
+		t.Errorf("Decoded %#v", decoded.Value)
+	}
+
+	for _, test := range []struct {
+		json     string
+		expected string
+	}{
+		{`{"name":"","type":"bool","value":true}`, `nbt: Unknown type "bool" in typed JSON`},
+		{`{"name":"","type":"byte","value":128}`, `nbt: Invalid TAG_Byte (0x01) in typed JSON: 128`},
+		{`{"name":"","type":"compound","value":[{"name":"a","type":"list","value":{"elem":"short","values":[1,1.5]}}]}`,
+			"nbt: Invalid TAG_Short (0x02) in typed JSON: 1.5\n\t\tat list index 1\n\t\tat struct field \"a\""},
+		{`{"name":"","type":"list","value":{"elem":"end","values":[1]}}`, `nbt: Unknown type "end" in typed JSON`},
+		{`{"name":"","type":"int"}`, `nbt: Missing value in typed JSON`},
+		{`{"name":"","type":"float","value":"NaN:0x3f800000"}`, `nbt: Invalid TAG_Float (0x05) in typed JSON: NaN:0x3f800000`},
+	} {
+		err := json.Unmarshal([]byte(test.json), &decoded)
+		if err == nil {
+			t.Errorf("%s: Decoded as %#v, but expected an error", test.json, decoded.Value)
+		} else if err.Error() != test.expected {
+			t.Errorf("%s: Error is %q, but expected %q", test.json, err, test.expected)
+		}
+	}
+}
+
+func TestJSONToNBTTrailingData(t *testing.T) {
+	for _, in := range []string{
+		`{"name":"","type":"int","value":1} {"name":"","type":"int","value":2}`,
+		`{"name":"","type":"int","value":1}]`,
+		`{"name":"","type":"int","value":1}x`,
+	} {
+		var out bytes.Buffer
+		if err := JSONToNBT(strings.NewReader(in), Uncompressed, &out); err == nil {
+			t.Errorf("%s: Converted to % x, but expected an error", in, out.Bytes())
+		}
+	}
+
+	var out bytes.Buffer
+	if err := JSONToNBT(strings.NewReader(`{"name":"","type":"int","value":1}`+"\n"), Uncompressed, &out); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMarshalPlainJSON(t *testing.T) {
+	root := new(Compound)
+	root.SetString("name", "<Bob>")
+	root.SetLong("seed", -5)
+	root.SetDouble("nan", math.NaN())
+	root.SetIntArray("uuid", []int32{1, 2})
+	root.SetList("pos", &List{Elem: TAG_Float, Values: []Value{Float(0.5), Float(64)}})
+	root.SetCompound("empty", new(Compound))
+
+	data, err := MarshalPlainJSON(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{"name":"<Bob>","seed":-5,"nan":"NaN","uuid":[1,2],"pos":[0.5,64],"empty":{}}`; string(data) != expected {
+		t.Errorf("Encoded %s, but expected %s", data, expected)
+	}
+
+	data, err = MarshalPlainJSON(struct {
+		Health float32
+		Tags   []string
+	}{20, []string{"a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{"Health":20,"Tags":["a"]}`; string(data) != expected {
+		t.Errorf("Encoded %s, but expected %s", data, expected)
+	}
+}
@@ -0,0 +1,387 @@
+package nbt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// The stream format written by lz4-java's LZ4BlockOutputStream: a sequence of blocks, each with
+// a 21 byte header, ending with an empty block.
+//
+//	magic            "LZ4Block"
+//	token            compression method (0x10 raw, 0x20 LZ4) | log2(block size) - 10
+//	compressed size  int32, little endian
+//	original size    int32, little endian
+//	checksum         XXH32 of the original data with seed 0x9747b28c, low 28 bits
+var lz4Magic = []byte("LZ4Block")
+
+const (
+	lz4HeaderSize = 21
+	lz4BlockSize  = 1 << 16
+
+	lz4MethodRaw = 0x10
+	lz4MethodLZ4 = 0x20
+
+	lz4Seed = 0x9747b28c
+)
+
+type lz4Reader struct {
+	in     io.Reader
+	header [lz4HeaderSize]byte
+	buf    []byte // The compressed block.
+	block  []byte // The decompressed block.
+	pos    int    // The number of bytes of block already returned.
+	done   bool
+}
+
+func newLZ4Reader(in io.Reader) *lz4Reader {
+	return &lz4Reader{in: in}
+}
+
+func (r *lz4Reader) Read(p []byte) (int, error) {
+	for r.pos == len(r.block) {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.block[r.pos:])
+	r.pos += n
+	return n, nil
+}
+
+// Reads and decompresses the next block.
+func (r *lz4Reader) next() error {
+	if _, err := io.ReadFull(r.in, r.header[:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if string(r.header[:len(lz4Magic)]) != string(lz4Magic) {
+		return fmt.Errorf("nbt: Invalid LZ4 block header")
+	}
+
+	method := r.header[8] & 0xf0
+	compressedSize := int(int32(binary.LittleEndian.Uint32(r.header[9:])))
+	size := int(int32(binary.LittleEndian.Uint32(r.header[13:])))
+	checksum := binary.LittleEndian.Uint32(r.header[17:])
+	maxSize := 1 << (10 + uint(r.header[8]&0x0f))
+
+	if size < 0 || size > maxSize || compressedSize < 0 || compressedSize > maxSize+maxSize/255+16 ||
+		(method == lz4MethodRaw && compressedSize != size) || (method != lz4MethodRaw && method != lz4MethodLZ4) {
+		return fmt.Errorf("nbt: Invalid LZ4 block header")
+	}
+	if size == 0 {
+		r.done = true
+		r.block, r.pos = r.block[:0], 0
+		return nil
+	}
+
+	if cap(r.buf) < compressedSize {
+		r.buf = make([]byte, compressedSize)
+	}
+	buf := r.buf[:compressedSize]
+	if _, err := io.ReadFull(r.in, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	if cap(r.block) < size {
+		r.block = make([]byte, size)
+	}
+	r.block, r.pos = r.block[:size], 0
+	if method == lz4MethodRaw {
+		copy(r.block, buf)
+	} else if err := lz4Decompress(r.block, buf); err != nil {
+		return err
+	}
+
+	if xxh32(r.block, lz4Seed)&0x0fffffff != checksum {
+		return fmt.Errorf("nbt: LZ4 block checksum mismatch")
+	}
+	return nil
+}
+
+// Decompresses an LZ4 block into dst, which must have exactly the size of the original data.
+func lz4Decompress(dst, src []byte) error {
+	corrupt := fmt.Errorf("nbt: Corrupt LZ4 block")
+
+	var i, j int
+	for {
+		if i >= len(src) {
+			return corrupt
+		}
+		token := src[i]
+		i++
+
+		literals := int(token >> 4)
+		if literals == 15 {
+			for {
+				if i >= len(src) {
+					return corrupt
+				}
+				b := src[i]
+				i++
+				literals += int(b)
+				if b != 255 {
+					break
+				}
+			}
+		}
+		if literals > len(src)-i || literals > len(dst)-j {
+			return corrupt
+		}
+		j += copy(dst[j:], src[i:i+literals])
+		i += literals
+
+		// The last sequence has no match.
+		if i == len(src) {
+			if j != len(dst) {
+				return corrupt
+			}
+			return nil
+		}
+
+		if i+2 > len(src) {
+			return corrupt
+		}
+		offset := int(src[i]) | int(src[i+1])<<8
+		i += 2
+		if offset == 0 || offset > j {
+			return corrupt
+		}
+
+		length := int(token & 15)
+		if length == 15 {
+			for {
+				if i >= len(src) {
+					return corrupt
+				}
+				b := src[i]
+				i++
+				length += int(b)
+				if b != 255 {
+					break
+				}
+			}
+		}
+		length += 4
+		if length > len(dst)-j {
+			return corrupt
+		}
+
+		// The match may overlap the bytes it produces, so it is copied one byte at a time.
+		for k := 0; k < length; k++ {
+			dst[j] = dst[j-offset]
+			j++
+		}
+	}
+}
+
+type lz4Writer struct {
+	out   io.Writer
+	block []byte // Data not yet written.
+	buf   []byte
+	err   error
+}
+
+func newLZ4Writer(out io.Writer) *lz4Writer {
+	return &lz4Writer{out: out, block: make([]byte, 0, lz4BlockSize)}
+}
+
+func (w *lz4Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	var n int
+	for len(p) > 0 {
+		k := copy(w.block[len(w.block):cap(w.block)], p)
+		w.block = w.block[:len(w.block)+k]
+		n += k
+		p = p[k:]
+
+		if len(w.block) == cap(w.block) {
+			if err := w.Flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Writes the buffered data as a block.
+func (w *lz4Writer) Flush() error {
+	if w.err != nil || len(w.block) == 0 {
+		return w.err
+	}
+
+	w.buf = lz4Compress(append(w.buf[:0], make([]byte, lz4HeaderSize)...), w.block)
+	method := byte(lz4MethodLZ4)
+	if len(w.buf)-lz4HeaderSize >= len(w.block) {
+		method = lz4MethodRaw
+		w.buf = append(w.buf[:lz4HeaderSize], w.block...)
+	}
+	w.writeHeader(method, len(w.buf)-lz4HeaderSize, len(w.block), xxh32(w.block, lz4Seed)&0x0fffffff)
+
+	_, w.err = w.out.Write(w.buf)
+	w.block = w.block[:0]
+	return w.err
+}
+
+// Flushes the buffered data and writes the empty block that ends the stream. The underlying
+// writer is not closed.
+func (w *lz4Writer) Close() error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	w.buf = append(w.buf[:0], make([]byte, lz4HeaderSize)...)
+	w.writeHeader(lz4MethodRaw, 0, 0, 0)
+	if _, w.err = w.out.Write(w.buf); w.err == nil {
+		w.err = fmt.Errorf("nbt: LZ4 writer is closed")
+		return nil
+	}
+	return w.err
+}
+
+func (w *lz4Writer) writeHeader(method byte, compressedSize, size int, checksum uint32) {
+	copy(w.buf, lz4Magic)
+	w.buf[8] = method | 6 // log2(lz4BlockSize) - 10
+	binary.LittleEndian.PutUint32(w.buf[9:], uint32(compressedSize))
+	binary.LittleEndian.PutUint32(w.buf[13:], uint32(size))
+	binary.LittleEndian.PutUint32(w.buf[17:], checksum)
+}
+
+// Appends the LZ4 block compression of src to dst. This is a simple greedy compressor: it finds
+// fewer matches than the reference implementation, but its output is valid for any decoder.
+func lz4Compress(dst, src []byte) []byte {
+	const (
+		minMatch  = 4
+		lastBytes = 5  // The last bytes of a block are always literals.
+		lastMatch = 12 // No match may start closer than this to the end of a block.
+		hashBits  = 14
+	)
+
+	var table [1 << hashBits]int32 // Positions + 1 of recent 4 byte sequences.
+	anchor := 0
+
+	if len(src) > lastMatch {
+		for i := 0; i < len(src)-lastMatch; {
+			seq := binary.LittleEndian.Uint32(src[i:])
+			h := (seq * 2654435761) >> (32 - hashBits)
+			ref := int(table[h]) - 1
+			table[h] = int32(i + 1)
+
+			if ref < 0 || i-ref > 0xffff || binary.LittleEndian.Uint32(src[ref:]) != seq {
+				i++
+				continue
+			}
+
+			end := i + minMatch
+			for end < len(src)-lastBytes && src[end] == src[ref+end-i] {
+				end++
+			}
+
+			dst = lz4AppendSequence(dst, src[anchor:i], i-ref, end-i)
+			i, anchor = end, end
+		}
+	}
+
+	return lz4AppendSequence(dst, src[anchor:], 0, 0)
+}
+
+// Appends a sequence of literals followed by a match. A length of 0 means there is no match,
+// which is only allowed for the last sequence.
+func lz4AppendSequence(dst, literals []byte, offset, length int) []byte {
+	token := byte(0)
+	if len(literals) >= 15 {
+		token = 15 << 4
+	} else {
+		token = byte(len(literals)) << 4
+	}
+	if length != 0 {
+		if length-4 >= 15 {
+			token |= 15
+		} else {
+			token |= byte(length - 4)
+		}
+	}
+
+	dst = append(dst, token)
+	if len(literals) >= 15 {
+		dst = lz4AppendLength(dst, len(literals)-15)
+	}
+	dst = append(dst, literals...)
+	if length == 0 {
+		return dst
+	}
+
+	dst = append(dst, byte(offset), byte(offset>>8))
+	if length-4 >= 15 {
+		dst = lz4AppendLength(dst, length-4-15)
+	}
+	return dst
+}
+
+func lz4AppendLength(dst []byte, n int) []byte {
+	for n >= 255 {
+		dst = append(dst, 255)
+		n -= 255
+	}
+	return append(dst, byte(n))
+}
+
+// The 32 bit xxHash of p.
+func xxh32(p []byte, seed uint32) uint32 {
+	const (
+		prime1 = 2654435761
+		prime2 = 2246822519
+		prime3 = 3266489917
+		prime4 = 668265263
+		prime5 = 374761393
+	)
+	rotl := func(x uint32, r uint) uint32 {
+		return x<<r | x>>(32-r)
+	}
+
+	n := len(p)
+	var h uint32
+	if n >= 16 {
+		v1 := seed + prime1 + prime2
+		v2 := seed + prime2
+		v3 := seed
+		v4 := seed - prime1
+		for ; len(p) >= 16; p = p[16:] {
+			v1 = rotl(v1+binary.LittleEndian.Uint32(p[0:])*prime2, 13) * prime1
+			v2 = rotl(v2+binary.LittleEndian.Uint32(p[4:])*prime2, 13) * prime1
+			v3 = rotl(v3+binary.LittleEndian.Uint32(p[8:])*prime2, 13) * prime1
+			v4 = rotl(v4+binary.LittleEndian.Uint32(p[12:])*prime2, 13) * prime1
+		}
+		h = rotl(v1, 1) + rotl(v2, 7) + rotl(v3, 12) + rotl(v4, 18)
+	} else {
+		h = seed + prime5
+	}
+
+	h += uint32(n)
+	for ; len(p) >= 4; p = p[4:] {
+		h = rotl(h+binary.LittleEndian.Uint32(p)*prime3, 17) * prime4
+	}
+	for _, b := range p {
+		h = rotl(h+uint32(b)*prime5, 11) * prime1
+	}
+
+	h ^= h >> 15
+	h *= prime2
+	h ^= h >> 13
+	h *= prime3
+	h ^= h >> 16
+	return h
+}
@@ -0,0 +1,207 @@
+package nbt
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+)
+
+// Marshaler is implemented by types that control their own NBT representation. MarshalNBT must
+// call enc.Encode exactly once. It is used for root values, struct fields, map values and list
+// elements; every element of a list must encode to the same tag.
+type Marshaler interface {
+	MarshalNBT(enc *ValueEncoder) error
+}
+
+// Unmarshaler is implemented by types that control how they are decoded from NBT. UnmarshalNBT
+// may inspect dec.Tag() and then call dec.Decode at most once. If it returns without calling
+// Decode, the value is skipped.
+type Unmarshaler interface {
+	UnmarshalNBT(dec *ValueDecoder) error
+}
+
+var (
+	marshalerType   = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+)
+
+// A ValueEncoder writes the single value that a Marshaler stands for.
+type ValueEncoder struct {
+	e       *encodeState
+	tag     Tag
+	payload bytes.Buffer
+	done    bool
+	err     *EncodeError
+}
+
+// Encodes v in place of the Marshaler. To use the default encoding of the Marshaler's own type,
+// convert it to a type without a MarshalNBT method first, or the call will never end.
+func (enc *ValueEncoder) Encode(v interface{}) (err error) {
+	if enc.done {
+		return fmt.Errorf("nbt: MarshalNBT called Encode more than once")
+	}
+	enc.done = true
+
+	defer func() {
+		if r := recover(); r != nil {
+			enc.err = encodeError(r)
+			err = enc.err
+		}
+	}()
+
+	// The value is written with the same settings as the value containing it.
+	var buf bytes.Buffer
+	e := *enc.e
+	e.out = &buf
+	e.writeTag("", reflect.ValueOf(v))
+
+	// Drop the tag type and the length of the empty name.
+	b := buf.Bytes()
+	enc.tag = Tag(b[0])
+	if e.varint {
+		enc.payload.Write(b[2:])
+	} else {
+		enc.payload.Write(b[3:])
+	}
+	return
+}
+
+// A ValueDecoder reads the single value that an Unmarshaler stands for.
+type ValueDecoder struct {
+	d    *decodeState
+	tag  Tag
+	done bool
+	err  *DecodeError
+}
+
+// Returns the tag of the value being decoded.
+func (dec *ValueDecoder) Tag() Tag {
+	return dec.tag
+}
+
+// Decodes the value into the value pointed to by v. To use the default decoding of the
+// Unmarshaler's own type, convert it to a type without an UnmarshalNBT method first, or the call
+// will never end.
+func (dec *ValueDecoder) Decode(v interface{}) (err error) {
+	if dec.done {
+		return fmt.Errorf("nbt: UnmarshalNBT called Decode more than once")
+	}
+	dec.done = true
+
+	defer func() {
+		if r := recover(); r != nil {
+			dec.err = dec.d.error(r)
+			err = dec.err
+		}
+	}()
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		panic(dec.d.errorf("nbt: Decode requires a non-nil pointer, not %v", reflect.TypeOf(v)))
+	}
+	dec.d.readValue(dec.tag, rv.Elem())
+	return
+}
+
+// Returns v as a Marshaler if its type or a pointer to it implements Marshaler.
+func asMarshaler(v reflect.Value) (Marshaler, bool) {
+	if !v.IsValid() || !v.CanInterface() || (v.Kind() == reflect.Ptr && v.IsNil()) {
+		return nil, false
+	}
+	if m, ok := v.Interface().(Marshaler); ok {
+		return m, true
+	}
+	if v.Kind() != reflect.Ptr && reflect.PtrTo(v.Type()).Implements(marshalerType) {
+		if v.CanAddr() {
+			return v.Addr().Interface().(Marshaler), true
+		}
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		return p.Interface().(Marshaler), true
+	}
+	return nil, false
+}
+
+// Returns the tag and payload of a Marshaler.
+func (e *encodeState) marshal(m Marshaler) (Tag, []byte) {
+	enc := &ValueEncoder{e: e}
+	err := m.MarshalNBT(enc)
+	if enc.err != nil {
+		panic(enc.err)
+	}
+	if err != nil {
+		e := encodeError(err)
+		e.Type = reflect.TypeOf(m)
+		panic(e)
+	}
+	if !enc.done {
+		panic(unhandledType(reflect.TypeOf(m), "nbt: MarshalNBT of %T did not call Encode", m))
+	}
+	return enc.tag, enc.payload.Bytes()
+}
+
+// Writes a slice of Marshalers as a list.
+func (e *encodeState) writeMarshalerList(v reflect.Value) {
+	tags := make([]Tag, v.Len())
+	payloads := make([][]byte, v.Len())
+
+	var i int
+	defer func() {
+		if r := recover(); r != nil {
+			panicAt(r, indexSegment(i))
+		}
+	}()
+
+	for i = 0; i < v.Len(); i++ {
+		m, ok := asMarshaler(v.Index(i))
+		if !ok {
+			panic(fmt.Errorf("nbt: Unhandled nil value"))
+		}
+		tags[i], payloads[i] = e.marshal(m)
+		if tags[i] != tags[0] {
+			panic(fmt.Errorf("nbt: MarshalNBT returned a %s in a list of %s", tags[i], tags[0]))
+		}
+	}
+
+	if len(tags) == 0 {
+		e.writeByte(byte(TAG_End))
+	} else {
+		e.writeByte(byte(tags[0]))
+	}
+	e.writeLength(v.Len())
+	for _, payload := range payloads {
+		e.write(payload)
+	}
+}
+
+// Returns v as an Unmarshaler if a pointer to it implements Unmarshaler. Nil pointers are
+// allocated.
+func asUnmarshaler(v reflect.Value) (Unmarshaler, bool) {
+	if v.Kind() == reflect.Ptr && v.Type().Implements(unmarshalerType) {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return v.Interface().(Unmarshaler), true
+	}
+	if v.Kind() != reflect.Ptr && v.CanAddr() && reflect.PtrTo(v.Type()).Implements(unmarshalerType) {
+		return v.Addr().Interface().(Unmarshaler), true
+	}
+	return nil, false
+}
+
+func (d *decodeState) callUnmarshaler(tag Tag, u Unmarshaler) {
+	dec := &ValueDecoder{d: d, tag: tag}
+	err := u.UnmarshalNBT(dec)
+	if dec.err != nil {
+		panic(dec.err)
+	}
+	if err != nil {
+		e := d.error(err)
+		e.Actual = tag
+		e.Type = reflect.TypeOf(u)
+		panic(e)
+	}
+	if !dec.done {
+		d.skipValue(tag)
+	}
+}
@@ -0,0 +1,151 @@
+package nbt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+type UUID [16]byte
+
+// UUIDs are stored as four ints, most significant first.
+func (u UUID) MarshalNBT(enc *ValueEncoder) error {
+	var ints [4]int32
+	for i := range ints {
+		ints[i] = int32(u[i*4])<<24 | int32(u[i*4+1])<<16 | int32(u[i*4+2])<<8 | int32(u[i*4+3])
+	}
+	return enc.Encode(ints)
+}
+
+// Old versions stored UUIDs as strings.
+func (u *UUID) UnmarshalNBT(dec *ValueDecoder) error {
+	switch dec.Tag() {
+	case TAG_Int_Array:
+		var ints [4]int32
+		if err := dec.Decode(&ints); err != nil {
+			return err
+		}
+		for i, n := range ints {
+			u[i*4], u[i*4+1], u[i*4+2], u[i*4+3] = byte(n>>24), byte(n>>16), byte(n>>8), byte(n)
+		}
+		return nil
+
+	case TAG_String:
+		var s string
+		if err := dec.Decode(&s); err != nil {
+			return err
+		}
+		b, err := hex.DecodeString(s)
+		if err != nil || len(b) != 16 {
+			return fmt.Errorf("invalid UUID %#v", s)
+		}
+		copy(u[:], b)
+		return nil
+	}
+	return fmt.Errorf("cannot decode a UUID from a %s", dec.Tag())
+}
+
+type BlockPos struct {
+	X, Y, Z int32
+}
+
+func (p *BlockPos) MarshalNBT(enc *ValueEncoder) error {
+	return enc.Encode([3]int32{p.X, p.Y, p.Z})
+}
+
+func (p *BlockPos) UnmarshalNBT(dec *ValueDecoder) error {
+	var xyz [3]int32
+	if err := dec.Decode(&xyz); err != nil {
+		return err
+	}
+	p.X, p.Y, p.Z = xyz[0], xyz[1], xyz[2]
+	return nil
+}
+
+type Marshalers struct {
+	Owner   UUID
+	Friends []UUID
+	Home    *BlockPos
+	Beds    map[string]BlockPos
+	Ignored BlockPos
+}
+
+func TestMarshaler(t *testing.T) {
+	in := Marshalers{
+		Owner:   UUID{0: 0x12, 15: 0x34},
+		Friends: []UUID{{1: 1}, {14: 2}},
+		Home:    &BlockPos{1, 64, -1},
+		Beds:    map[string]BlockPos{"overworld": {5, 70, 5}},
+	}
+
+	var encoded bytes.Buffer
+	if err := Marshal(Uncompressed, &encoded, in); err != nil {
+		t.Fatal(err)
+	}
+	data := encoded.Bytes()
+
+	var root *Compound
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &root); err != nil {
+		t.Fatal(err)
+	}
+	if owner, ok := root.GetIntArray("Owner"); !ok || len(owner) != 4 || owner[0] != 0x12000000 || owner[3] != 0x34 {
+		t.Errorf("Owner == %#v", root.Get("Owner"))
+	}
+	if friends, ok := root.GetList("Friends"); !ok || friends.Elem != TAG_Int_Array || friends.Len() != 2 {
+		t.Errorf("Friends == %#v", root.Get("Friends"))
+	}
+
+	var out Marshalers
+	if err := Unmarshal(Uncompressed, bytes.NewReader(data), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Owner != in.Owner {
+		t.Errorf("Owner == %x", out.Owner)
+	}
+	if len(out.Friends) != 2 || out.Friends[0] != in.Friends[0] || out.Friends[1] != in.Friends[1] {
+		t.Errorf("Friends == %x", out.Friends)
+	}
+	if out.Home == nil || *out.Home != *in.Home {
+		t.Errorf("Home == %#v", out.Home)
+	}
+	if out.Beds["overworld"] != in.Beds["overworld"] {
+		t.Errorf("Beds == %#v", out.Beds)
+	}
+}
+
+func TestUnmarshalerError(t *testing.T) {
+	root := new(Compound)
+	root.SetString("Owner", "not a uuid")
+
+	var encoded bytes.Buffer
+	if err := Marshal(Uncompressed, &encoded, root); err != nil {
+		t.Fatal(err)
+	}
+
+	var out struct{ Owner UUID }
+	err := Unmarshal(Uncompressed, &encoded, &out)
+	if err == nil {
+		t.Error("No error, but one was expected!")
+	} else if err.Error() != "invalid UUID \"not a uuid\"\n\t\tat struct field \"Owner\"" {
+		t.Error(err)
+	}
+}
+
+// Ignores the error from Encode, which can't write a channel.
+type CarelessMarshaler struct{}
+
+func (CarelessMarshaler) MarshalNBT(enc *ValueEncoder) error {
+	enc.Encode(make(chan int))
+	return nil
+}
+
+func TestMarshalerIgnoredError(t *testing.T) {
+	var encoded bytes.Buffer
+	err := Marshal(Uncompressed, &encoded, struct{ M CarelessMarshaler }{})
+	if err == nil {
+		t.Errorf("No error, but one was expected! Encoded % x", encoded.Bytes())
+	} else if e, ok := err.(*EncodeError); !ok || len(e.Path) != 1 || e.Path[0].Name != "M" {
+		t.Errorf("Error is %q, but expected it at struct field \"M\"", err)
+	}
+}
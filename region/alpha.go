@@ -0,0 +1,121 @@
+package region
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Nightgunner5/go.nbt"
+)
+
+// An Alpha is a world saved in the format used before region files. Each chunk is a gzipped NBT
+// file at X/Z/c.x.z.dat in the world directory, where x and z are the chunk coordinates in
+// base 36 and X and Z are the same coordinates modulo 64. Chunks are read with absolute
+// coordinates and hold a LegacyChunk.
+type Alpha struct {
+	dir string
+}
+
+// Opens the alpha world in dir, the directory holding level.dat.
+func OpenAlpha(dir string) (*Alpha, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("region: %s is not a directory", dir)
+	}
+	return &Alpha{dir: dir}, nil
+}
+
+// Returns the path of the file a chunk is stored in.
+func (a *Alpha) Path(x, z int) string {
+	return filepath.Join(a.dir,
+		strconv.FormatInt(int64(x&63), 36), strconv.FormatInt(int64(z&63), 36),
+		"c."+strconv.FormatInt(int64(x), 36)+"."+strconv.FormatInt(int64(z), 36)+".dat")
+}
+
+// Lists the chunks in the world, in no particular order.
+func (a *Alpha) Chunks() []ChunkInfo {
+	files, _ := filepath.Glob(filepath.Join(a.dir, "*", "*", "c.*.*.dat"))
+
+	var chunks []ChunkInfo
+	for _, path := range files {
+		x, z, ok := parseAlphaName(filepath.Base(path))
+		if !ok || a.Path(x, z) != path {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		chunks = append(chunks, ChunkInfo{X: x, Z: z, Timestamp: info.ModTime()})
+	}
+	return chunks
+}
+
+// Parses the chunk coordinates out of the name of a chunk file.
+func parseAlphaName(name string) (x, z int, ok bool) {
+	parts := strings.Split(name, ".")
+	if len(parts) != 4 || parts[0] != "c" || parts[3] != "dat" {
+		return 0, 0, false
+	}
+	x64, err := strconv.ParseInt(parts[1], 36, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+	z64, err := strconv.ParseInt(parts[2], 36, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+	return int(x64), int(z64), true
+}
+
+// Reports whether a chunk is present in the world.
+func (a *Alpha) HasChunk(x, z int) bool {
+	info, err := os.Stat(a.Path(x, z))
+	return err == nil && info.Mode().IsRegular()
+}
+
+// Returns the time a chunk was last saved, which alpha worlds only record as the time the file
+// was changed.
+func (a *Alpha) Timestamp(x, z int) time.Time {
+	info, err := os.Stat(a.Path(x, z))
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// Returns a reader for the NBT data of a chunk and the compression to decode it with.
+func (a *Alpha) ChunkReader(x, z int) (io.Reader, nbt.Compression, error) {
+	data, err := ioutil.ReadFile(a.Path(x, z))
+	if os.IsNotExist(err) {
+		return nil, 0, ErrNotFound
+	} else if err != nil {
+		return nil, 0, err
+	}
+	return bytes.NewReader(data), nbt.GZip, nil
+}
+
+// Decodes a chunk and stores it in the value pointed to by v, as Region.Unmarshal does.
+func (a *Alpha) Unmarshal(x, z int, v interface{}) error {
+	in, compression, err := a.ChunkReader(x, z)
+	if err != nil {
+		return err
+	}
+	dec := nbt.NewDecoder(in)
+	dec.SetCompression(compression)
+	return dec.Decode(v)
+}
+
+// Does nothing; chunk files are only open while they are read.
+func (a *Alpha) Close() error {
+	return nil
+}
@@ -0,0 +1,73 @@
+package region
+
+import "github.com/Nightgunner5/go.nbt"
+
+// LegacyHeight is the height of the world in MCRegion and alpha chunks.
+const LegacyHeight = 128
+
+// LegacyChunk is the NBT layout of chunks in MCRegion files and alpha worlds. Unlike Anvil
+// chunks, they aren't split into sections: each block array covers the whole 16×128×16 chunk.
+type LegacyChunk struct {
+	Level LegacyLevel
+}
+
+type LegacyLevel struct {
+	XPos             int32 `nbt:"xPos"`
+	ZPos             int32 `nbt:"zPos"`
+	LastUpdate       int64 // In ticks.
+	TerrainPopulated bool
+
+	// The blocks of the chunk, as described by LegacyIndex: one byte of block ID and four bits
+	// each of block data and light.
+	Blocks     []byte  `nbt:",bytearray"`
+	Data       Nibbles `nbt:",bytearray"`
+	SkyLight   Nibbles `nbt:",bytearray"`
+	BlockLight Nibbles `nbt:",bytearray"`
+
+	// The lowest Y at which the light from the sky is at full strength, for each column,
+	// indexed by x + z*16.
+	HeightMap []byte `nbt:",bytearray"`
+
+	Entities     []*nbt.Compound
+	TileEntities []*nbt.Compound
+
+	Rest *nbt.Compound `nbt:",rest"`
+}
+
+// Returns the index of a block in the block arrays of a legacy chunk. The coordinates are
+// relative to the chunk, with x and z from 0 to 15 and y from 0 to 127.
+func LegacyIndex(x, y, z int) int {
+	return y + z*LegacyHeight + x*LegacyHeight*16
+}
+
+// Returns the ID and data of a block, or zeros if the arrays are too short to hold it.
+func (l *LegacyLevel) Block(x, y, z int) (id, data byte) {
+	i := LegacyIndex(x, y, z)
+	if i < len(l.Blocks) {
+		id = l.Blocks[i]
+	}
+	return id, l.Data.Get(i)
+}
+
+// Nibbles is an array of four bit values, two to a byte. The even elements are in the low bits.
+type Nibbles []byte
+
+// Returns the element at index i, or 0 if the array is too short.
+func (n Nibbles) Get(i int) byte {
+	if i/2 >= len(n) {
+		return 0
+	}
+	if i&1 == 0 {
+		return n[i/2] & 0x0f
+	}
+	return n[i/2] >> 4
+}
+
+// Sets the element at index i to the low four bits of v.
+func (n Nibbles) Set(i int, v byte) {
+	if i&1 == 0 {
+		n[i/2] = n[i/2]&0xf0 | v&0x0f
+	} else {
+		n[i/2] = n[i/2]&0x0f | v<<4
+	}
+}
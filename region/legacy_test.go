@@ -0,0 +1,150 @@
+package region
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/Nightgunner5/go.nbt"
+)
+
+func testLegacyChunk(x, z int) LegacyChunk {
+	level := LegacyLevel{
+		XPos:       int32(x),
+		ZPos:       int32(z),
+		LastUpdate: 1234,
+		Blocks:     make([]byte, 16*LegacyHeight*16),
+		Data:       make(Nibbles, 16*LegacyHeight*16/2),
+		SkyLight:   make(Nibbles, 16*LegacyHeight*16/2),
+		BlockLight: make(Nibbles, 16*LegacyHeight*16/2),
+		HeightMap:  make([]byte, 16*16),
+	}
+	level.Blocks[LegacyIndex(3, 64, 5)] = 35 // Wool
+	level.Data.Set(LegacyIndex(3, 64, 5), 14)
+	level.Data.Set(LegacyIndex(3, 65, 5), 7)
+	return LegacyChunk{Level: level}
+}
+
+func checkLegacyChunk(t *testing.T, source ChunkSource, x, z int) {
+	var chunk LegacyChunk
+	if err := source.Unmarshal(x, z, &chunk); err != nil {
+		t.Errorf("Chunk %d,%d: %v", x, z, err)
+		return
+	}
+	if chunk.Level.XPos != int32(x) || chunk.Level.ZPos != int32(z) || chunk.Level.LastUpdate != 1234 {
+		t.Errorf("Chunk %d,%d: Level == %d,%d %d", x, z, chunk.Level.XPos, chunk.Level.ZPos, chunk.Level.LastUpdate)
+	}
+	if id, data := chunk.Level.Block(3, 64, 5); id != 35 || data != 14 {
+		t.Errorf("Chunk %d,%d: Block(3, 64, 5) == %d:%d", x, z, id, data)
+	}
+	if id, data := chunk.Level.Block(3, 65, 5); id != 0 || data != 7 {
+		t.Errorf("Chunk %d,%d: Block(3, 65, 5) == %d:%d", x, z, id, data)
+	}
+	if len(chunk.Level.SkyLight) != 16*LegacyHeight*16/2 {
+		t.Errorf("Chunk %d,%d: SkyLight has %d bytes", x, z, len(chunk.Level.SkyLight))
+	}
+
+	// Minecraft only reads the block arrays as byte arrays, not lists of bytes.
+	var tree *nbt.Compound
+	if err := source.Unmarshal(x, z, &tree); err != nil {
+		t.Errorf("Chunk %d,%d: %v", x, z, err)
+		return
+	}
+	level, _ := tree.GetCompound("Level")
+	for _, name := range []string{"Blocks", "Data", "SkyLight", "BlockLight", "HeightMap"} {
+		if v := level.Get(name); v == nil {
+			t.Errorf("Chunk %d,%d: %s is missing", x, z, name)
+		} else if v.Tag() != nbt.TAG_Byte_Array {
+			t.Errorf("Chunk %d,%d: %s is a %s, but expected a TAG_Byte_Array", x, z, name, v.Tag())
+		}
+	}
+}
+
+func TestAlpha(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alpha")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	coords := [][2]int{{0, 0}, {-1, 37}, {100, -64}}
+	for _, c := range coords {
+		var data bytes.Buffer
+		if err := nbt.Marshal(nbt.GZip, &data, testLegacyChunk(c[0], c[1])); err != nil {
+			t.Fatal(err)
+		}
+		a := &Alpha{dir: dir}
+		path := a.Path(c[0], c[1])
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, data.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a, err := OpenAlpha(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path := a.Path(-1, 37); path != filepath.Join(dir, "1r", "11", "c.-1.11.dat") {
+		t.Errorf("Path(-1, 37) == %q", path)
+	}
+
+	var source ChunkSource = a
+	infos := source.Chunks()
+	var found [][2]int
+	for _, info := range infos {
+		found = append(found, [2]int{info.X, info.Z})
+	}
+	sort.Slice(found, func(i, j int) bool {
+		return found[i][0] < found[j][0]
+	})
+	if len(found) != 3 || found[0] != coords[1] || found[1] != coords[0] || found[2] != coords[2] {
+		t.Errorf("Chunks() == %v", found)
+	}
+
+	for _, c := range coords {
+		if !source.HasChunk(c[0], c[1]) {
+			t.Errorf("Chunk %d,%d is missing", c[0], c[1])
+		}
+		checkLegacyChunk(t, source, c[0], c[1])
+	}
+	if source.HasChunk(1, 1) {
+		t.Errorf("Chunk 1,1 is present")
+	}
+	var chunk LegacyChunk
+	if err := source.Unmarshal(1, 1, &chunk); err != ErrNotFound {
+		t.Errorf("Reading a missing chunk returned %v", err)
+	}
+}
+
+func TestMCRegion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "region")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "r.-1.0.mcr")
+
+	w, err := OpenWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Marshal(-1, 4, testLegacyChunk(-1, 4)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	checkLegacyChunk(t, r, -1, 4)
+}
@@ -0,0 +1,296 @@
+// Package region reads and writes Minecraft Anvil region files (.mca), which store the NBT data
+// of 32×32 chunks.
+//
+// A region file starts with two 4KiB tables of 1024 entries, indexed by x + 32*z with chunk
+// coordinates relative to the region. The location table gives the offset and length of each
+// chunk in 4KiB sectors, and the timestamp table gives the time each chunk was last saved, in
+// seconds since the Unix epoch. Each chunk starts with its length in bytes and a compression
+// type, followed by the compressed NBT data. Chunks too big for the region file are stored in a
+// separate c.X.Z.mcc file next to it.
+//
+// MCRegion files (.mcr), used before Anvil, have the same layout and are opened the same way;
+// only the NBT inside the chunks differs, as described by LegacyChunk. Worlds from before region
+// files, which store each chunk in its own file, are read with OpenAlpha.
+package region
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Nightgunner5/go.nbt"
+)
+
+const (
+	SectorSize = 4096 // The unit of allocation in a region file.
+	Width      = 32   // The number of chunks along each side of a region.
+
+	headerSectors = 2
+	chunkHeader   = 5 // The length and compression type before the data of a chunk.
+)
+
+// Compression types as stored in region files. These are not the same numbers as nbt.Compression.
+const (
+	CompressionGZip         byte = 1
+	CompressionZLib         byte = 2
+	CompressionUncompressed byte = 3
+	CompressionLZ4          byte = 4
+	CompressionCustom       byte = 127 // Followed by the name of the algorithm.
+
+	externalFlag byte = 0x80 // The data is in a .mcc file.
+)
+
+// Returns the nbt.Compression for a compression type stored in a region file.
+func Compression(b byte) (nbt.Compression, bool) {
+	switch b {
+	case CompressionGZip:
+		return nbt.GZip, true
+	case CompressionZLib:
+		return nbt.ZLib, true
+	case CompressionUncompressed:
+		return nbt.Uncompressed, true
+	case CompressionLZ4:
+		return nbt.LZ4, true
+	}
+	return 0, false
+}
+
+// ErrNotFound is returned for chunks that are not present in a region.
+var ErrNotFound = errors.New("region: Chunk not found")
+
+// A Region is an open region file.
+type Region struct {
+	f    *os.File
+	path string
+	size int64
+
+	// The position of the region in regions, if the file name says so. It is needed to find the
+	// .mcc files of external chunks.
+	x, z  int
+	named bool
+
+	locations  [Width * Width]uint32
+	timestamps [Width * Width]uint32
+
+	// Paths of .mcc files that a Writer hasn't moved into place yet, by index.
+	externals map[int]string
+}
+
+// ChunkInfo describes a chunk present in a region.
+type ChunkInfo struct {
+	X, Z      int // Relative to the region, from 0 to 31. Absolute in alpha worlds.
+	Timestamp time.Time
+
+	Offset  int // In sectors from the start of the file. Zero in alpha worlds.
+	Sectors int
+}
+
+// ChunkSource is the way chunks are read, whatever format they are stored in. It is implemented
+// by *Region, for Anvil and MCRegion files, and by *Alpha.
+type ChunkSource interface {
+	// Lists the chunks present.
+	Chunks() []ChunkInfo
+	// Reports whether a chunk is present.
+	HasChunk(x, z int) bool
+	// Returns the time a chunk was last saved.
+	Timestamp(x, z int) time.Time
+	// Returns a reader for the NBT data of a chunk and the compression to decode it with, or
+	// ErrNotFound if the chunk is not present.
+	ChunkReader(x, z int) (io.Reader, nbt.Compression, error)
+	// Decodes a chunk into v, skipping compound entries without a struct field.
+	Unmarshal(x, z int, v interface{}) error
+	Close() error
+}
+
+// Opens a region file for reading. If the file is called r.X.Z.mca, chunks stored in .mcc files
+// can be read too.
+func Open(path string) (*Region, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := newRegion(f, path)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reads the tables of a region file. The path is only used for its name.
+func newRegion(f *os.File, path string) (*Region, error) {
+	r := &Region{f: f, path: path}
+	if _, err := fmt.Sscanf(filepath.Base(path), "r.%d.%d.", &r.x, &r.z); err == nil {
+		r.named = true
+	}
+	if err := r.readHeader(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Region) readHeader() error {
+	info, err := r.f.Stat()
+	if err != nil {
+		return err
+	}
+	r.size = info.Size()
+
+	// An empty file is an empty region.
+	if r.size == 0 {
+		return nil
+	}
+	if r.size < headerSectors*SectorSize {
+		return fmt.Errorf("region: %s is too short to be a region file", r.path)
+	}
+
+	header := make([]byte, headerSectors*SectorSize)
+	if _, err := r.f.ReadAt(header, 0); err != nil {
+		return err
+	}
+	for i := range r.locations {
+		r.locations[i] = binary.BigEndian.Uint32(header[i*4:])
+		r.timestamps[i] = binary.BigEndian.Uint32(header[SectorSize+i*4:])
+	}
+	return nil
+}
+
+func (r *Region) Close() error {
+	return r.f.Close()
+}
+
+// Returns the index of a chunk in the tables. Chunk coordinates may be absolute or relative to
+// the region; only their position inside the region counts.
+func index(x, z int) int {
+	return (x & (Width - 1)) + (z&(Width-1))*Width
+}
+
+// Lists the chunks present in the region, in the order of the location table.
+func (r *Region) Chunks() []ChunkInfo {
+	var chunks []ChunkInfo
+	for i, location := range r.locations {
+		if location == 0 {
+			continue
+		}
+		chunks = append(chunks, ChunkInfo{
+			X:         i % Width,
+			Z:         i / Width,
+			Timestamp: time.Unix(int64(r.timestamps[i]), 0),
+			Offset:    int(location >> 8),
+			Sectors:   int(location & 0xff),
+		})
+	}
+	return chunks
+}
+
+// Reports whether a chunk is present in the region.
+func (r *Region) HasChunk(x, z int) bool {
+	return r.locations[index(x, z)] != 0
+}
+
+// Returns the time a chunk was last saved.
+func (r *Region) Timestamp(x, z int) time.Time {
+	return time.Unix(int64(r.timestamps[index(x, z)]), 0)
+}
+
+// Returns the compressed data of a chunk and its compression type as stored in the region file,
+// without the external flag. For external chunks, the data is read from the .mcc file.
+func (r *Region) ReadChunk(x, z int) (data []byte, compression byte, err error) {
+	location := r.locations[index(x, z)]
+	if location == 0 {
+		return nil, 0, ErrNotFound
+	}
+
+	offset, sectors := int64(location>>8)*SectorSize, int64(location&0xff)*SectorSize
+	if offset < headerSectors*SectorSize || offset+sectors > r.size {
+		return nil, 0, problem(x, z, OutOfBounds, nil)
+	}
+
+	var header [chunkHeader]byte
+	if _, err := r.f.ReadAt(header[:], offset); err != nil {
+		return nil, 0, err
+	}
+	length := int64(binary.BigEndian.Uint32(header[:]))
+	compression = header[4]
+	if length < 1 || length+4 > sectors {
+		return nil, 0, problem(x, z, BadLength, fmt.Errorf("%d bytes in %d sectors", length, sectors/SectorSize))
+	}
+
+	if compression&externalFlag != 0 {
+		data, err = r.readExternal(x, z)
+		if err != nil {
+			return nil, 0, problem(x, z, MissingExternal, err)
+		}
+		return data, compression &^ externalFlag, nil
+	}
+
+	data = make([]byte, length-1)
+	if _, err := r.f.ReadAt(data, offset+chunkHeader); err != nil {
+		return nil, 0, err
+	}
+	return data, compression, nil
+}
+
+// Returns the path of the .mcc file a chunk is stored in if it is too big for the region file.
+func (r *Region) ExternalPath(x, z int) (string, error) {
+	if !r.named {
+		return "", fmt.Errorf("region: The position of %s is unknown, so it has no external chunks", r.path)
+	}
+	x, z = r.x*Width+x&(Width-1), r.z*Width+z&(Width-1)
+	return filepath.Join(filepath.Dir(r.path), fmt.Sprintf("c.%d.%d.mcc", x, z)), nil
+}
+
+func (r *Region) readExternal(x, z int) ([]byte, error) {
+	if path, ok := r.externals[index(x, z)]; ok {
+		return ioutil.ReadFile(path)
+	}
+	path, err := r.ExternalPath(x, z)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(path)
+}
+
+// Returns a reader for the NBT data of a chunk and the compression to decode it with.
+func (r *Region) ChunkReader(x, z int) (io.Reader, nbt.Compression, error) {
+	data, compression, err := r.ReadChunk(x, z)
+	if err != nil {
+		return nil, 0, err
+	}
+	c, ok := Compression(compression)
+	if !ok {
+		if compression == CompressionCustom {
+			return nil, 0, problem(x, z, UnknownCompression, fmt.Errorf("custom compression %q", customName(data)))
+		}
+		return nil, 0, problem(x, z, UnknownCompression, fmt.Errorf("type %d", compression))
+	}
+	return bytes.NewReader(data), c, nil
+}
+
+// Returns the name of the algorithm at the start of custom-compressed data.
+func customName(data []byte) string {
+	if len(data) < 2 || len(data) < 2+int(binary.BigEndian.Uint16(data)) {
+		return ""
+	}
+	return string(data[2 : 2+binary.BigEndian.Uint16(data)])
+}
+
+// Decodes a chunk and stores it in the value pointed to by v, which may be a struct, a map or a
+// *nbt.Compound. Unlike nbt.Unmarshal, compound entries without a struct field are skipped, so
+// a struct only needs the fields the caller is interested in.
+func (r *Region) Unmarshal(x, z int, v interface{}) error {
+	in, compression, err := r.ChunkReader(x, z)
+	if err != nil {
+		return err
+	}
+	dec := nbt.NewDecoder(in)
+	dec.SetCompression(compression)
+	return dec.Decode(v)
+}
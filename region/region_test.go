@@ -0,0 +1,142 @@
+package region
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Nightgunner5/go.nbt"
+)
+
+type Chunk struct {
+	DataVersion int32
+	XPos        int32 `nbt:"xPos"`
+	ZPos        int32 `nbt:"zPos"`
+	Status      string
+}
+
+type testChunk struct {
+	x, z        int
+	compression byte
+	external    bool
+}
+
+// Writes a region file by hand, with chunks stored the way Minecraft stores them.
+func writeTestRegion(t *testing.T, dir string, rx, rz int, chunks []testChunk) string {
+	file := make([]byte, headerSectors*SectorSize)
+	for i, c := range chunks {
+		chunk := Chunk{DataVersion: 3953, XPos: int32(rx*Width + c.x), ZPos: int32(rz*Width + c.z), Status: "minecraft:full"}
+		compression, _ := Compression(c.compression)
+		var data bytes.Buffer
+		if err := nbt.Marshal(compression, &data, chunk); err != nil {
+			t.Fatal(err)
+		}
+
+		payload := append([]byte{c.compression}, data.Bytes()...)
+		if c.external {
+			name := filepath.Join(dir, fmt.Sprintf("c.%d.%d.mcc", rx*Width+c.x, rz*Width+c.z))
+			if err := ioutil.WriteFile(name, data.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+			payload = []byte{c.compression | externalFlag}
+		}
+
+		offset := len(file) / SectorSize
+		sector := make([]byte, (4+len(payload)+SectorSize-1)/SectorSize*SectorSize)
+		binary.BigEndian.PutUint32(sector, uint32(len(payload)))
+		copy(sector[4:], payload)
+		file = append(file, sector...)
+
+		binary.BigEndian.PutUint32(file[index(c.x, c.z)*4:], uint32(offset<<8|len(sector)/SectorSize))
+		binary.BigEndian.PutUint32(file[SectorSize+index(c.x, c.z)*4:], uint32(1700000000+i))
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("r.%d.%d.mca", rx, rz))
+	if err := ioutil.WriteFile(path, file, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "region")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	chunks := []testChunk{
+		{0, 0, CompressionZLib, false},
+		{31, 0, CompressionGZip, false},
+		{5, 7, CompressionUncompressed, false},
+		{6, 7, CompressionLZ4, false},
+		{31, 31, CompressionZLib, true},
+	}
+	path := writeTestRegion(t, dir, -1, 2, chunks)
+
+	r, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	infos := r.Chunks()
+	if len(infos) != len(chunks) {
+		t.Fatalf("Found %d chunks, but expected %d", len(infos), len(chunks))
+	}
+	for i, c := range chunks {
+		if !r.HasChunk(c.x, c.z) {
+			t.Errorf("Chunk %d,%d is missing", c.x, c.z)
+			continue
+		}
+		if ts := r.Timestamp(c.x, c.z); !ts.Equal(time.Unix(int64(1700000000+i), 0)) {
+			t.Errorf("Chunk %d,%d was saved at %v", c.x, c.z, ts)
+		}
+
+		var chunk Chunk
+		// Absolute chunk coordinates work too.
+		if err := r.Unmarshal(-Width+c.x, 2*Width+c.z, &chunk); err != nil {
+			t.Errorf("Chunk %d,%d: %v", c.x, c.z, err)
+			continue
+		}
+		expected := Chunk{DataVersion: 3953, XPos: int32(-Width + c.x), ZPos: int32(2*Width + c.z), Status: "minecraft:full"}
+		if !reflect.DeepEqual(chunk, expected) {
+			t.Errorf("Chunk %d,%d == %#v", c.x, c.z, chunk)
+		}
+	}
+
+	var tree *nbt.Compound
+	if err := r.Unmarshal(1, 1, &tree); err != ErrNotFound {
+		t.Errorf("Reading a missing chunk returned %v", err)
+	}
+	if err := r.Unmarshal(0, 0, &tree); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := tree.GetString("Status"); status != "minecraft:full" {
+		t.Errorf("Status == %#v", status)
+	}
+}
+
+func TestReadEmpty(t *testing.T) {
+	f, err := ioutil.TempFile("", "r.0.0.mca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	r, err := Open(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if len(r.Chunks()) != 0 {
+		t.Errorf("An empty file has chunks: %v", r.Chunks())
+	}
+}
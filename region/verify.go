@@ -0,0 +1,181 @@
+package region
+
+import (
+	"fmt"
+
+	"github.com/Nightgunner5/go.nbt"
+)
+
+// The kinds of problem Verify finds.
+type ProblemKind int
+
+const (
+	OutOfBounds        ProblemKind = iota // The location points into the header or past the end of the file.
+	Overlapping                           // The chunk shares sectors with another chunk.
+	BadLength                             // The length of the chunk doesn't fit in its sectors.
+	UnknownCompression                    // The compression type is not one this package can decode.
+	MissingExternal                       // The chunk is stored in a .mcc file that can't be read.
+	BadNBT                                // The chunk data can't be decompressed or decoded.
+)
+
+var problemKindNames = []string{
+	OutOfBounds:        "is outside of the file",
+	Overlapping:        "shares sectors with another chunk",
+	BadLength:          "has an invalid length",
+	UnknownCompression: "has an unknown compression type",
+	MissingExternal:    "has no readable .mcc file",
+	BadNBT:             "can't be decoded",
+}
+
+func (k ProblemKind) String() string {
+	if k < 0 || int(k) >= len(problemKindNames) {
+		return fmt.Sprintf("ProblemKind(%d)", int(k))
+	}
+	return problemKindNames[k]
+}
+
+// A Problem is something wrong with a chunk. Reading a chunk that can't be read returns a
+// *Problem as the error.
+type Problem struct {
+	X, Z int // Relative to the region, from 0 to 31.
+	Kind ProblemKind
+	Err  error // Details, if there are any.
+}
+
+func problem(x, z int, kind ProblemKind, err error) *Problem {
+	return &Problem{X: x & (Width - 1), Z: z & (Width - 1), Kind: kind, Err: err}
+}
+
+func (p *Problem) Error() string {
+	msg := fmt.Sprintf("region: Chunk %d,%d %v", p.X, p.Z, p.Kind)
+	if p.Err != nil {
+		msg += ": " + p.Err.Error()
+	}
+	return msg
+}
+
+// Report sums up the state of a region file.
+type Report struct {
+	Chunks int // Chunks in the location table.
+	Good   int // Chunks that can be read and decoded.
+
+	// The length of the file and the sectors used by the tables and the good chunks. Compact
+	// reclaims the rest.
+	Sectors     int
+	UsedSectors int
+}
+
+// Checks every chunk in the region and calls report, if it is not nil, for each problem found.
+// A chunk can have more than one problem. Chunks that share sectors with another are still
+// counted as good if they decode, because usually one of them does.
+//
+// The error is only set if the file can't be read at all.
+func (r *Region) Verify(report func(*Problem)) (Report, error) {
+	rep, _, err := r.verify(report)
+	return rep, err
+}
+
+// Does the work of Verify, and also returns the indices of the good chunks.
+func (r *Region) verify(report func(*Problem)) (rep Report, good []int, err error) {
+	if report == nil {
+		report = func(*Problem) {}
+	}
+	rep.Sectors = int((r.size + SectorSize - 1) / SectorSize)
+
+	users := make([]int, rep.Sectors)
+	for _, location := range r.locations {
+		offset, sectors := int(location>>8), int(location&0xff)
+		if location == 0 || offset < headerSectors || int64(offset+sectors)*SectorSize > r.size {
+			continue
+		}
+		for s := offset; s < offset+sectors; s++ {
+			users[s]++
+		}
+	}
+
+	used := make([]bool, rep.Sectors)
+	for s := 0; s < headerSectors && s < rep.Sectors; s++ {
+		used[s] = true
+	}
+
+	for i, location := range r.locations {
+		if location == 0 {
+			continue
+		}
+		rep.Chunks++
+		x, z := i%Width, i/Width
+
+		offset, sectors := int(location>>8), int(location&0xff)
+		if offset >= headerSectors && int64(offset+sectors)*SectorSize <= r.size {
+			for s := offset; s < offset+sectors; s++ {
+				if users[s] > 1 {
+					report(problem(x, z, Overlapping, nil))
+					break
+				}
+			}
+		}
+
+		in, compression, err := r.ChunkReader(x, z)
+		if p, ok := err.(*Problem); ok {
+			report(p)
+			continue
+		} else if err != nil {
+			return rep, nil, err
+		}
+
+		// Nothing is stored, but the whole chunk is still read and checked.
+		var skip struct{}
+		dec := nbt.NewDecoder(in)
+		dec.SetCompression(compression)
+		if err := dec.Decode(&skip); err != nil {
+			report(problem(x, z, BadNBT, err))
+			continue
+		}
+
+		rep.Good++
+		good = append(good, i)
+		for s := offset; s < offset+sectors; s++ {
+			used[s] = true
+		}
+	}
+
+	for _, u := range used {
+		if u {
+			rep.UsedSectors++
+		}
+	}
+	return rep, good, nil
+}
+
+// Writes a copy of the region to path that holds only the chunks Verify finds good, packed
+// together in the order of the location table. Problems are passed to report as by Verify.
+// The path may be the region's own file, which is replaced once the copy is complete. Chunks
+// stored in .mcc files are written next to path, named after the position of this region.
+func (r *Region) Compact(path string, report func(*Problem)) (Report, error) {
+	rep, good, err := r.verify(report)
+	if err != nil {
+		return rep, err
+	}
+
+	w, err := Create(path)
+	if err != nil {
+		return rep, err
+	}
+	if r.named {
+		// Chunks too big for the region still belong to this region's position, even if path
+		// doesn't say what it is.
+		w.x, w.z, w.named = r.x, r.z, true
+	}
+	for _, i := range good {
+		x, z := i%Width, i/Width
+		data, compression, err := r.ReadChunk(x, z)
+		if err == nil {
+			err = w.WriteChunk(x, z, compression, data, r.Timestamp(x, z))
+		}
+		if err != nil {
+			w.Abort()
+			return rep, err
+		}
+	}
+	return rep, w.Close()
+}
@@ -0,0 +1,159 @@
+package region
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Nightgunner5/go.nbt"
+)
+
+func TestVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "region")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var chunks []testChunk
+	for x := 0; x < 8; x++ {
+		chunks = append(chunks, testChunk{x, 0, CompressionZLib, x == 7})
+	}
+	path := writeTestRegion(t, dir, 0, 0, chunks)
+
+	// Each chunk takes one sector, in order after the tables.
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sector := func(x int) []byte {
+		return file[(headerSectors+x)*SectorSize:]
+	}
+	sector(2)[4] = 42                                 // Unknown compression.
+	copy(sector(3)[chunkHeader:], "not zlib data")    // Undecodable.
+	binary.BigEndian.PutUint32(sector(4), SectorSize) // Longer than its sector.
+	copy(file[5*4:], file[0:4])                       // Chunk 5,0 points at chunk 0,0.
+	binary.BigEndian.PutUint32(file[6*4:], 1000<<8|1) // Past the end of the file.
+	if err := ioutil.WriteFile(path, file, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "c.7.0.mcc")); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	problems := make(map[[2]int][]ProblemKind)
+	report, err := r.Verify(func(p *Problem) {
+		problems[[2]int{p.X, p.Z}] = append(problems[[2]int{p.X, p.Z}], p.Kind)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[[2]int][]ProblemKind{
+		{0, 0}: {Overlapping},
+		{2, 0}: {UnknownCompression},
+		{3, 0}: {BadNBT},
+		{4, 0}: {BadLength},
+		{5, 0}: {Overlapping},
+		{6, 0}: {OutOfBounds},
+		{7, 0}: {MissingExternal},
+	}
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("Verify found %v, but expected %v", problems, expected)
+	}
+	if expected := (Report{Chunks: 8, Good: 3, Sectors: 10, UsedSectors: 4}); report != expected {
+		t.Errorf("Verify reported %+v, but expected %+v", report, expected)
+	}
+
+	// Compact the region in place.
+	if _, err := r.Compact(path, nil); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	r, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err = r.Verify(func(p *Problem) {
+		t.Errorf("Compacted region: %v", p)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (Report{Chunks: 3, Good: 3, Sectors: 5, UsedSectors: 5}); report != expected {
+		t.Errorf("Compacted region: Verify reported %+v, but expected %+v", report, expected)
+	}
+	for _, x := range []int{0, 1, 5} {
+		var chunk Chunk
+		if err := r.Unmarshal(x, 0, &chunk); err != nil {
+			t.Errorf("Chunk %d,0: %v", x, err)
+		} else if chunk.XPos != int32(x) && x != 5 {
+			t.Errorf("Chunk %d,0 == %#v", x, chunk)
+		}
+		if ts := r.Timestamp(x, 0).Unix(); ts != int64(1700000000+x) {
+			t.Errorf("Chunk %d,0 was saved at %d", x, ts)
+		}
+	}
+}
+
+func TestCompactExternal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "region")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "r.1.-1.mca")
+
+	w, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	huge := new(nbt.Compound)
+	huge.SetByteArray("Data", make([]byte, 2<<20))
+	w.SetCompression(CompressionUncompressed)
+	if err := w.Marshal(3, 4, huge); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The copy goes to a file that isn't named after the region, in a directory of its own.
+	out := filepath.Join(dir, "out")
+	if err := os.Mkdir(out, 0755); err != nil {
+		t.Fatal(err)
+	}
+	r, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := r.Compact(filepath.Join(out, "copy.mca"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Rename(filepath.Join(out, "copy.mca"), filepath.Join(out, "r.1.-1.mca")); err != nil {
+		t.Fatal(err)
+	}
+	copied, err := Open(filepath.Join(out, "r.1.-1.mca"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer copied.Close()
+	var tree *nbt.Compound
+	if err := copied.Unmarshal(3, 4, &tree); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := tree.GetByteArray("Data"); len(data) != 2<<20 {
+		t.Errorf("The copied chunk has %d bytes of data", len(data))
+	}
+}
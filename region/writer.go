@@ -0,0 +1,333 @@
+package region
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Nightgunner5/go.nbt"
+)
+
+// The most sectors a chunk can use in a region file. Bigger chunks are stored in .mcc files.
+const maxSectors = 255
+
+// A Writer changes the chunks of a region file. The changes are made to a copy of the file, which
+// replaces the original when the Writer is closed, so a crash never leaves a half-written region
+// behind. Chunks can be read through the Writer, including the ones it has changed.
+//
+// A Writer is not safe for concurrent use.
+type Writer struct {
+	*Region
+
+	compression byte
+	used        []int // How many chunks use each sector. The header is always in use.
+
+	staleExternals map[string]bool // .mcc files to remove once the region is in place.
+	closed         bool
+}
+
+// Opens a region file for writing, creating it if it doesn't exist. Changes only take effect
+// when Close is called; use Abort to throw them away.
+func OpenWriter(path string) (*Writer, error) {
+	return openWriter(path, true)
+}
+
+// Starts a new, empty region file that replaces the file at path, if there is one, when the
+// Writer is closed.
+func Create(path string) (*Writer, error) {
+	return openWriter(path, false)
+}
+
+func openWriter(path string, keep bool) (*Writer, error) {
+	dir, base := filepath.Split(path)
+	tmp, err := ioutil.TempFile(dir, base+".tmp")
+	if err != nil {
+		return nil, err
+	}
+	fail := func(err error) (*Writer, error) {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	if src, err := os.Open(path); err == nil {
+		if keep {
+			_, err = io.Copy(tmp, src)
+		}
+		if info, statErr := src.Stat(); err == nil && statErr == nil {
+			err = tmp.Chmod(info.Mode())
+		}
+		src.Close()
+		if err != nil {
+			return fail(err)
+		}
+	} else if !os.IsNotExist(err) {
+		return fail(err)
+	} else if err := tmp.Chmod(0644); err != nil {
+		return fail(err)
+	}
+
+	r, err := newRegion(tmp, path)
+	if err != nil {
+		return fail(err)
+	}
+	r.externals = make(map[int]string)
+	if r.size < headerSectors*SectorSize {
+		if err := tmp.Truncate(headerSectors * SectorSize); err != nil {
+			return fail(err)
+		}
+		r.size = headerSectors * SectorSize
+	}
+
+	w := &Writer{
+		Region:         r,
+		compression:    CompressionZLib,
+		used:           make([]int, r.size/SectorSize),
+		staleExternals: make(map[string]bool),
+	}
+	for i := 0; i < headerSectors; i++ {
+		w.used[i]++
+	}
+	for i, location := range r.locations {
+		offset, sectors := int(location>>8), int(location&0xff)
+		if offset < headerSectors || offset+sectors > len(w.used) {
+			// Unreadable entries are dropped, so their space can't be claimed twice.
+			r.locations[i], r.timestamps[i] = 0, 0
+			continue
+		}
+		for s := offset; s < offset+sectors; s++ {
+			w.used[s]++
+		}
+	}
+	return w, nil
+}
+
+// Sets the compression type used by Marshal. The default is CompressionZLib, like Minecraft.
+func (w *Writer) SetCompression(compression byte) {
+	w.compression = compression
+}
+
+// Encodes v with nbt.Marshal and stores it as a chunk, saved now.
+func (w *Writer) Marshal(x, z int, v interface{}) error {
+	compression, ok := Compression(w.compression)
+	if !ok {
+		return fmt.Errorf("region: Can't compress chunks with compression type %d", w.compression)
+	}
+
+	var data bytes.Buffer
+	if err := nbt.Marshal(compression, &data, v); err != nil {
+		return err
+	}
+	return w.WriteChunk(x, z, w.compression, data.Bytes(), time.Now())
+}
+
+// Stores already compressed chunk data, replacing the chunk if it exists. Chunks that don't fit
+// in 255 sectors (about 1MiB) are stored in a .mcc file.
+func (w *Writer) WriteChunk(x, z int, compression byte, data []byte, timestamp time.Time) error {
+	if w.closed {
+		return fmt.Errorf("region: Writer is closed")
+	}
+	i := index(x, z)
+
+	// The old chunk stays in place until the new one is written, so a failed write loses nothing.
+	sectors := (chunkHeader + len(data) + SectorSize - 1) / SectorSize
+	external := ""
+	if sectors > maxSectors {
+		path, err := w.writeExternal(x, z, data)
+		if err != nil {
+			return err
+		}
+		external = path
+		compression |= externalFlag
+		data = nil
+		sectors = 1
+	}
+
+	offset := w.allocate(sectors)
+	buf := make([]byte, sectors*SectorSize)
+	binary.BigEndian.PutUint32(buf, uint32(len(data)+1))
+	buf[4] = compression
+	copy(buf[chunkHeader:], data)
+	if _, err := w.f.WriteAt(buf, int64(offset)*SectorSize); err != nil {
+		for s := offset; s < offset+sectors; s++ {
+			w.used[s]--
+		}
+		if external != "" {
+			os.Remove(external)
+		}
+		return err
+	}
+
+	w.free(i)
+	if external != "" {
+		w.externals[i] = external
+	}
+	w.locations[i] = uint32(offset<<8 | sectors)
+	w.timestamps[i] = uint32(timestamp.Unix())
+	return nil
+}
+
+// Removes a chunk from the region.
+func (w *Writer) DeleteChunk(x, z int) error {
+	if w.closed {
+		return fmt.Errorf("region: Writer is closed")
+	}
+	i := index(x, z)
+	w.free(i)
+	w.locations[i], w.timestamps[i] = 0, 0
+	return nil
+}
+
+// Releases the sectors of a chunk, and its .mcc file if it has one.
+func (w *Writer) free(i int) {
+	location := w.locations[i]
+	if location == 0 {
+		return
+	}
+
+	offset, sectors := int(location>>8), int(location&0xff)
+	for s := offset; s < offset+sectors; s++ {
+		w.used[s]--
+	}
+
+	if path, ok := w.externals[i]; ok {
+		os.Remove(path)
+		delete(w.externals, i)
+	}
+	var header [chunkHeader]byte
+	if _, err := w.f.ReadAt(header[:], int64(offset)*SectorSize); err == nil && header[4]&externalFlag != 0 {
+		if path, err := w.ExternalPath(i%Width, i/Width); err == nil {
+			w.staleExternals[path] = true
+		}
+	}
+}
+
+// Returns the first run of free sectors long enough for a chunk, growing the file if needed.
+func (w *Writer) allocate(sectors int) int {
+	run := 0
+	for s := headerSectors; s < len(w.used); s++ {
+		if w.used[s] != 0 {
+			run = 0
+			continue
+		}
+		if run++; run == sectors {
+			w.claim(s-sectors+1, sectors)
+			return s - sectors + 1
+		}
+	}
+
+	// Extend the free run at the end of the file, if there is one.
+	offset := len(w.used) - run
+	for len(w.used) < offset+sectors {
+		w.used = append(w.used, 0)
+	}
+	w.claim(offset, sectors)
+	w.size = int64(len(w.used)) * SectorSize
+	return offset
+}
+
+func (w *Writer) claim(offset, sectors int) {
+	for s := offset; s < offset+sectors; s++ {
+		w.used[s]++
+	}
+}
+
+// Writes the data of a chunk to a temporary file next to its .mcc file.
+func (w *Writer) writeExternal(x, z int, data []byte) (string, error) {
+	path, err := w.ExternalPath(x, z)
+	if err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return "", err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// Writes the tables, drops unused sectors from the end of the file and replaces the original
+// region file with the changed copy.
+func (w *Writer) Close() error {
+	if w.closed {
+		return fmt.Errorf("region: Writer is closed")
+	}
+
+	header := make([]byte, headerSectors*SectorSize)
+	for i := range w.locations {
+		binary.BigEndian.PutUint32(header[i*4:], w.locations[i])
+		binary.BigEndian.PutUint32(header[SectorSize+i*4:], w.timestamps[i])
+	}
+	if _, err := w.f.WriteAt(header, 0); err != nil {
+		w.Abort()
+		return err
+	}
+
+	end := len(w.used)
+	for end > headerSectors && w.used[end-1] == 0 {
+		end--
+	}
+	if err := w.f.Truncate(int64(end) * SectorSize); err != nil {
+		w.Abort()
+		return err
+	}
+	if err := w.f.Sync(); err != nil {
+		w.Abort()
+		return err
+	}
+	if err := w.f.Close(); err != nil {
+		w.Abort()
+		return err
+	}
+	w.closed = true
+
+	// New .mcc files go first. Until the region is replaced, they are only ever newer versions
+	// of chunks the old region also stores externally.
+	for i, tmp := range w.externals {
+		path, _ := w.ExternalPath(i%Width, i/Width)
+		if err := os.Rename(tmp, path); err != nil {
+			return err
+		}
+		delete(w.staleExternals, path)
+	}
+	if err := os.Rename(w.f.Name(), w.path); err != nil {
+		return err
+	}
+	for path := range w.staleExternals {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Throws away every change and leaves the region file as it was.
+func (w *Writer) Abort() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	for _, path := range w.externals {
+		os.Remove(path)
+	}
+	w.f.Close()
+	return os.Remove(w.f.Name())
+}
@@ -0,0 +1,181 @@
+package region
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Nightgunner5/go.nbt"
+)
+
+func TestWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "region")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "r.1.-1.mca")
+
+	w, err := OpenWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x := 0; x < 3; x++ {
+		if err := w.Marshal(x, 0, Chunk{DataVersion: 3953, XPos: int32(Width + x)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replace the middle chunk with one that doesn't fit in its sector, and put a new chunk in the
+	// space it leaves.
+	w, err = OpenWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	random := rand.New(rand.NewSource(1))
+	noise := make([]byte, 3*SectorSize)
+	random.Read(noise)
+	big := new(nbt.Compound)
+	big.SetByteArray("Noise", noise)
+	w.SetCompression(CompressionUncompressed)
+	if err := w.Marshal(1, 0, big); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Marshal(5, 5, Chunk{DataVersion: 3953, XPos: Width + 5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.DeleteChunk(2, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Huge chunks go to a .mcc file.
+	huge := make([]byte, 2<<20)
+	random.Read(huge)
+	if err := w.WriteChunk(31, 31, CompressionUncompressed, huge, time.Unix(1700000000, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if data, compression, err := w.ReadChunk(31, 31); err != nil || compression != CompressionUncompressed || len(data) != len(huge) {
+		t.Errorf("Reading the huge chunk back gave %d bytes, compression %d, %v", len(data), compression, err)
+	}
+
+	offsets := make(map[[2]int]int)
+	for _, info := range w.Chunks() {
+		offsets[[2]int{info.X, info.Z}] = info.Offset
+	}
+	if offsets[[2]int{5, 5}] != 3 {
+		t.Errorf("The new chunk is at sector %d, but expected the freed sector 3", offsets[[2]int{5, 5}])
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if r.HasChunk(2, 0) {
+		t.Error("The deleted chunk is still there")
+	}
+	var chunk Chunk
+	if err := r.Unmarshal(5, 5, &chunk); err != nil || chunk.XPos != Width+5 {
+		t.Errorf("Chunk 5,5 == %#v, %v", chunk, err)
+	}
+	var tree *nbt.Compound
+	if err := r.Unmarshal(1, 0, &tree); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := tree.GetByteArray("Noise"); string(data) != string(noise) {
+		t.Error("Chunk 1,0 changed")
+	}
+	if data, _, err := r.ReadChunk(31, 31); err != nil || string(data) != string(huge) {
+		t.Errorf("The huge chunk was not stored: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "c.63.-1.mcc")); err != nil {
+		t.Error(err)
+	}
+
+	// The header, chunk 0,0, chunk 5,5, the .mcc entry of chunk 31,31 where chunk 2,0 was, and
+	// four sectors of chunk 1,0. Nothing is left over.
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 9*SectorSize {
+		t.Errorf("The region file is %d bytes long", info.Size())
+	}
+
+	// Deleting the huge chunk removes its .mcc file, but only when the Writer is closed.
+	w, err = OpenWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.DeleteChunk(31, 31)
+	if err := w.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "c.63.-1.mcc")); err != nil {
+		t.Error(err)
+	}
+	w, err = OpenWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.DeleteChunk(31, 31)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "c.63.-1.mcc")); !os.IsNotExist(err) {
+		t.Errorf("The .mcc file is still there: %v", err)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		for _, f := range files {
+			t.Log(f.Name())
+		}
+		t.Errorf("Found %d files, but expected only the region file", len(files))
+	}
+}
+
+func TestWriterFailedWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "region")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Without a position in its name, the region can't store chunks in .mcc files.
+	w, err := OpenWriter(filepath.Join(dir, "world.mca"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Abort()
+	if err := w.Marshal(0, 0, Chunk{DataVersion: 3953, XPos: 0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteChunk(0, 0, CompressionUncompressed, make([]byte, 2<<20), time.Now()); err == nil {
+		t.Fatal("Writing a huge chunk succeeded")
+	}
+	if err := w.Marshal(1, 0, Chunk{DataVersion: 3953, XPos: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	var chunk Chunk
+	if err := w.Unmarshal(0, 0, &chunk); err != nil || chunk.XPos != 0 {
+		t.Errorf("Chunk 0,0 == %#v, %v", chunk, err)
+	}
+	if err := w.Unmarshal(1, 0, &chunk); err != nil || chunk.XPos != 1 {
+		t.Errorf("Chunk 1,0 == %#v, %v", chunk, err)
+	}
+}
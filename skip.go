@@ -0,0 +1,118 @@
+package nbt
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+)
+
+// Discards n bytes of input.
+func (d *decodeState) skip(n int64) {
+	var skipped int64
+	var err error
+	if in, ok := d.in.(*bufio.Reader); ok && int64(int(n)) == n {
+		var k int
+		k, err = in.Discard(int(n))
+		skipped = int64(k)
+	} else {
+		skipped, err = io.CopyN(ioutil.Discard, d.in, n)
+	}
+	d.offset += skipped
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Returns the size of the payload of tag, or 0 if it is not of a fixed size.
+func fixedSize(tag Tag) int64 {
+	switch tag {
+	case TAG_Byte:
+		return 1
+	case TAG_Short:
+		return 2
+	case TAG_Int, TAG_Float:
+		return 4
+	case TAG_Long, TAG_Double:
+		return 8
+	}
+	return 0
+}
+
+// Like fixedSize, but for the input being read, in which ints and longs may be varints.
+func (d *decodeState) payloadSize(tag Tag) int64 {
+	if d.varint && (tag == TAG_Int || tag == TAG_Long) {
+		return 0
+	}
+	return fixedSize(tag)
+}
+
+// Reads the payload of a tag without storing it anywhere.
+func (d *decodeState) skipValue(tag Tag) {
+	if size := d.payloadSize(tag); size != 0 {
+		d.skip(size)
+		return
+	}
+
+	switch tag {
+	case TAG_Int, TAG_Long:
+		d.readInt(tag)
+
+	case TAG_Byte_Array, TAG_Int_Array, TAG_Long_Array:
+		length := d.readLength()
+		d.checkLength(tag, length)
+		elem := arrayElem(tag)
+		if size := d.payloadSize(elem); size != 0 {
+			d.skip(int64(length) * size)
+			return
+		}
+		for i := uint32(0); i < length; i++ {
+			d.readInt(elem)
+		}
+
+	case TAG_String:
+		d.skip(int64(d.readStringLength()))
+
+	case TAG_List:
+		inner := Tag(d.readByte())
+		length := d.readLength()
+		d.checkLength(tag, length)
+
+		if size := d.payloadSize(inner); size != 0 {
+			d.skip(int64(length) * size)
+			return
+		}
+
+		d.enter()
+		defer d.leave()
+
+		var i uint32
+		defer func() {
+			if r := recover(); r != nil {
+				d.panicAt(r, indexSegment(int(i)))
+			}
+		}()
+
+		for i = 0; i < length; i++ {
+			d.skipValue(inner)
+		}
+
+	case TAG_Compound:
+		d.enter()
+		defer d.leave()
+
+		for {
+			tag := Tag(d.readByte())
+			if tag == TAG_End {
+				break
+			}
+			d.skipValue(TAG_String)
+			d.skipValue(tag)
+		}
+
+	default:
+		panic(d.errorf("nbt: Unhandled tag: %s", tag))
+	}
+}
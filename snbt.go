@@ -0,0 +1,648 @@
+package nbt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Parses SNBT, the text form of NBT used in Minecraft commands, such as
+// {Health:20.0f,Tags:["a","b"],Pos:[0.0d,64.0d,0.0d]}. The result is a tree value with the
+// tags Minecraft would give the same text: 20.0f is a Float, 20 an Int, 20.0 a Double, [I;1,2]
+// an IntArray and true a Byte of 1. An unquoted word that isn't a number, or a number that
+// doesn't fit its type, is a String.
+//
+// The elements of [B;...], [I;...] and [L;...] arrays may be any integers that fit the element
+// type, with or without a suffix.
+//
+// Errors are of type *DecodeError, with an Offset in bytes from the start of s.
+func ParseSNBT(s string) (v Value, err error) {
+	p := &snbtParser{s: s}
+	defer func() {
+		if r := recover(); r != nil {
+			err = decodeError(r, int64(p.pos))
+		}
+	}()
+
+	v = p.parseValue()
+	p.skipSpace()
+	if p.pos < len(p.s) {
+		panic(p.errorf("nbt: Expected the end of the SNBT, found %s", p.describe()))
+	}
+	return v, nil
+}
+
+// Parses SNBT and stores the result in the value pointed to by v, exactly as Unmarshal stores
+// the same data read from binary NBT.
+func UnmarshalSNBT(s string, v interface{}) error {
+	value, err := ParseSNBT(s)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := Marshal(Uncompressed, &buf, value); err != nil {
+		return err
+	}
+	return Unmarshal(Uncompressed, &buf, v)
+}
+
+// The forms of unquoted numbers, as in Minecraft. A double needs a decimal point unless it has a
+// suffix.
+var (
+	snbtInteger = regexp.MustCompile(`^[-+]?(?:0|[1-9][0-9]*)$`)
+	snbtDecimal = regexp.MustCompile(`^(?i)[-+]?(?:[0-9]+[.]?|[0-9]*[.][0-9]+)(?:e[-+]?[0-9]+)?$`)
+	snbtDouble  = regexp.MustCompile(`^(?i)[-+]?(?:[0-9]+[.]|[0-9]*[.][0-9]+)(?:e[-+]?[0-9]+)?$`)
+)
+
+type snbtParser struct {
+	s   string
+	pos int
+}
+
+func (p *snbtParser) errorf(format string, args ...interface{}) *DecodeError {
+	return &DecodeError{Msg: fmt.Sprintf(format, args...), Offset: int64(p.pos)}
+}
+
+// Describes the next character for an error message.
+func (p *snbtParser) describe() string {
+	if p.pos >= len(p.s) {
+		return "the end of the input"
+	}
+	r, _ := utf8.DecodeRuneInString(p.s[p.pos:])
+	return strconv.QuoteRune(r)
+}
+
+// Returns the next byte, or 0 at the end of the input.
+func (p *snbtParser) peek() byte {
+	if p.pos >= len(p.s) {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *snbtParser) skipSpace() {
+	for p.pos < len(p.s) {
+		switch p.s[p.pos] {
+		case ' ', '\t', '\n', '\r':
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (p *snbtParser) expect(c byte) {
+	p.skipSpace()
+	if p.peek() != c {
+		panic(p.errorf("nbt: Expected %q in SNBT, found %s", c, p.describe()))
+	}
+	p.pos++
+}
+
+// Skips a comma between elements and reports whether there was one. A comma may also come
+// after the last element.
+func (p *snbtParser) separator() bool {
+	p.skipSpace()
+	if p.peek() != ',' {
+		return false
+	}
+	p.pos++
+	p.skipSpace()
+	return true
+}
+
+func (p *snbtParser) parseValue() Value {
+	p.skipSpace()
+	switch p.peek() {
+	case '{':
+		return p.parseCompound()
+	case '[':
+		if p.pos+2 < len(p.s) && p.s[p.pos+2] == ';' {
+			return p.parseArray()
+		}
+		return p.parseList()
+	case '"', '\'':
+		return String(p.parseQuoted())
+	}
+
+	word := p.parseWord()
+	if word == "" {
+		panic(p.errorf("nbt: Expected a value in SNBT, found %s", p.describe()))
+	}
+	return snbtWord(word)
+}
+
+func isSNBTWordChar(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+		c == '_' || c == '-' || c == '.' || c == '+'
+}
+
+// Reads an unquoted word, which may be empty.
+func (p *snbtParser) parseWord() string {
+	start := p.pos
+	for p.pos < len(p.s) && isSNBTWordChar(p.s[p.pos]) {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+// Returns the value an unquoted word stands for.
+func snbtWord(word string) Value {
+	switch strings.ToLower(word) {
+	case "true":
+		return Byte(1)
+	case "false":
+		return Byte(0)
+	}
+
+	body := word[:len(word)-1]
+	switch word[len(word)-1] {
+	case 'b', 'B':
+		if n, err := strconv.ParseInt(body, 10, 8); err == nil && snbtInteger.MatchString(body) {
+			return Byte(n)
+		}
+	case 's', 'S':
+		if n, err := strconv.ParseInt(body, 10, 16); err == nil && snbtInteger.MatchString(body) {
+			return Short(n)
+		}
+	case 'l', 'L':
+		if n, err := strconv.ParseInt(body, 10, 64); err == nil && snbtInteger.MatchString(body) {
+			return Long(n)
+		}
+	case 'f', 'F':
+		if f, err := strconv.ParseFloat(body, 32); err == nil && snbtDecimal.MatchString(body) {
+			return Float(f)
+		}
+	case 'd', 'D':
+		if f, err := strconv.ParseFloat(body, 64); err == nil && snbtDecimal.MatchString(body) {
+			return Double(f)
+		}
+	}
+
+	if n, err := strconv.ParseInt(word, 10, 32); err == nil && snbtInteger.MatchString(word) {
+		return Int(n)
+	}
+	if f, err := strconv.ParseFloat(word, 64); err == nil && snbtDouble.MatchString(word) {
+		return Double(f)
+	}
+	return String(word)
+}
+
+// Reads a string in single or double quotes.
+func (p *snbtParser) parseQuoted() string {
+	quote := p.s[p.pos]
+	p.pos++
+
+	var b []byte
+	for {
+		if p.pos >= len(p.s) {
+			panic(p.errorf("nbt: Unterminated string in SNBT"))
+		}
+		c := p.s[p.pos]
+		if c == quote {
+			p.pos++
+			return string(b)
+		}
+		if c != '\\' {
+			b = append(b, c)
+			p.pos++
+			continue
+		}
+
+		p.pos++
+		switch p.peek() {
+		case '\\', '"', '\'':
+			b = append(b, p.s[p.pos])
+		case 'b':
+			b = append(b, '\b')
+		case 'f':
+			b = append(b, '\f')
+		case 'n':
+			b = append(b, '\n')
+		case 'r':
+			b = append(b, '\r')
+		case 's':
+			b = append(b, ' ')
+		case 't':
+			b = append(b, '\t')
+		case 'x', 'u', 'U':
+			digits := 2
+			if p.s[p.pos] == 'u' {
+				digits = 4
+			} else if p.s[p.pos] == 'U' {
+				digits = 8
+			}
+			if p.pos+1+digits > len(p.s) {
+				panic(p.errorf("nbt: Incomplete escape sequence in SNBT"))
+			}
+			r, err := strconv.ParseUint(p.s[p.pos+1:p.pos+1+digits], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(r)) {
+				panic(p.errorf("nbt: Invalid escape sequence \\%s in SNBT", p.s[p.pos:p.pos+1+digits]))
+			}
+			b = append(b, string(rune(r))...)
+			p.pos += digits
+		default:
+			panic(p.errorf("nbt: Invalid escape sequence \\%s in SNBT", p.describe()))
+		}
+		p.pos++
+	}
+}
+
+func (p *snbtParser) parseCompound() *Compound {
+	p.pos++ // {
+	compound := new(Compound)
+
+	var name string
+	defer func() {
+		if r := recover(); r != nil {
+			decodePanicAt(r, int64(p.pos), fieldSegment(name))
+		}
+	}()
+
+	for p.skipSpace(); p.peek() != '}'; {
+		switch c := p.peek(); {
+		case c == '"' || c == '\'':
+			name = p.parseQuoted()
+		case isSNBTWordChar(c):
+			name = p.parseWord()
+		default:
+			panic(p.errorf("nbt: Expected a compound key in SNBT, found %s", p.describe()))
+		}
+		p.expect(':')
+		compound.Set(name, p.parseValue())
+		if !p.separator() {
+			break
+		}
+	}
+	p.expect('}')
+	return compound
+}
+
+func (p *snbtParser) parseList() *List {
+	p.pos++ // [
+	list := new(List)
+
+	var i int
+	defer func() {
+		if r := recover(); r != nil {
+			decodePanicAt(r, int64(p.pos), indexSegment(i))
+		}
+	}()
+
+	for p.skipSpace(); p.peek() != ']'; i++ {
+		value := p.parseValue()
+		if err := list.Append(value); err != nil {
+			panic(p.errorf("%v", err))
+		}
+		if !p.separator() {
+			break
+		}
+	}
+	p.expect(']')
+	return list
+}
+
+// Parses a [B;...], [I;...] or [L;...] array.
+func (p *snbtParser) parseArray() Value {
+	kind := p.s[p.pos+1]
+	var bits int
+	switch kind {
+	case 'B':
+		bits = 8
+	case 'I':
+		bits = 32
+	case 'L':
+		bits = 64
+	default:
+		p.pos++
+		panic(p.errorf("nbt: Unknown array type %s in SNBT", p.describe()))
+	}
+	p.pos += 3
+
+	var values []int64
+	var i int
+	defer func() {
+		if r := recover(); r != nil {
+			decodePanicAt(r, int64(p.pos), indexSegment(i))
+		}
+	}()
+
+	for p.skipSpace(); p.peek() != ']'; i++ {
+		start := p.pos
+		var n int64
+		switch value := p.parseValue().(type) {
+		case Byte:
+			n = int64(value)
+		case Short:
+			n = int64(value)
+		case Int:
+			n = int64(value)
+		case Long:
+			n = int64(value)
+		default:
+			p.pos = start
+			panic(p.errorf("nbt: Cannot put a %s in a %s", value.Tag(), arrayTag(kind)))
+		}
+		if bits < 64 && (n < -1<<uint(bits-1) || n >= 1<<uint(bits-1)) {
+			p.pos = start
+			panic(p.errorf("nbt: Value %d overflows a %s", n, arrayTag(kind)))
+		}
+		values = append(values, n)
+		if !p.separator() {
+			break
+		}
+	}
+	p.expect(']')
+
+	switch kind {
+	case 'B':
+		array := make(ByteArray, len(values))
+		for i, n := range values {
+			array[i] = byte(n)
+		}
+		return array
+	case 'I':
+		array := make(IntArray, len(values))
+		for i, n := range values {
+			array[i] = int32(n)
+		}
+		return array
+	}
+	return LongArray(values)
+}
+
+func arrayTag(kind byte) Tag {
+	switch kind {
+	case 'B':
+		return TAG_Byte_Array
+	case 'I':
+		return TAG_Int_Array
+	}
+	return TAG_Long_Array
+}
+
+// Writes v as compact SNBT, which ParseSNBT turns back into the same tags. v may be a tree
+// value or any Go value Marshal accepts.
+func MarshalSNBT(v interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := NewSNBTEncoder(&buf).Encode(v); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// An SNBTEncoder writes values as SNBT, each followed by a newline. Floats and doubles that are
+// infinite or not a number have no SNBT form; they are written as Minecraft writes them, but
+// read back as strings.
+type SNBTEncoder struct {
+	out      io.Writer
+	indent   string
+	sortKeys bool
+}
+
+// Creates an SNBTEncoder writing compact SNBT to out.
+func NewSNBTEncoder(out io.Writer) *SNBTEncoder {
+	if out == nil {
+		panic(fmt.Errorf("nbt: Output stream is nil"))
+	}
+	return &SNBTEncoder{out: out}
+}
+
+// Puts each compound entry and each element of a list of compounds, lists or strings on a line
+// of its own, indented by indent for each level of nesting. Lists of numbers and arrays stay on
+// one line. An empty indent, the default, writes everything on one line without spaces.
+func (enc *SNBTEncoder) SetIndent(indent string) {
+	enc.indent = indent
+}
+
+// Writes compound entries sorted by name rather than in the order they were read or defined,
+// so equal values always give the same text.
+func (enc *SNBTEncoder) SortKeys() {
+	enc.sortKeys = true
+}
+
+// Writes v, which may be a tree value or any Go value Marshal accepts.
+func (enc *SNBTEncoder) Encode(v interface{}) (err error) {
+	value, err := toTree(v)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = encodeError(r)
+		}
+	}()
+
+	w := &snbtWriter{indent: enc.indent, sortKeys: enc.sortKeys}
+	w.value(value, 0)
+	w.buf.WriteByte('\n')
+	_, err = w.buf.WriteTo(enc.out)
+	return err
+}
+
+// Writes every root tag left in dec, such as a whole NBT file.
+func (enc *SNBTEncoder) EncodeAll(dec *Decoder) error {
+	for dec.More() {
+		var value Value
+		if err := dec.Decode(&value); err != nil {
+			return err
+		}
+		if err := enc.Encode(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type snbtWriter struct {
+	buf      bytes.Buffer
+	indent   string
+	sortKeys bool
+}
+
+// Starts a new line at the given depth when indenting.
+func (w *snbtWriter) newline(depth int) {
+	if w.indent == "" {
+		return
+	}
+	w.buf.WriteByte('\n')
+	for i := 0; i < depth; i++ {
+		w.buf.WriteString(w.indent)
+	}
+}
+
+// Writes the comma between elements, with a space after it when indenting.
+func (w *snbtWriter) comma() {
+	w.buf.WriteByte(',')
+	if w.indent != "" {
+		w.buf.WriteByte(' ')
+	}
+}
+
+func (w *snbtWriter) value(v Value, depth int) {
+	switch v := v.(type) {
+	case Byte:
+		w.buf.WriteString(strconv.FormatInt(int64(v), 10) + "b")
+	case Short:
+		w.buf.WriteString(strconv.FormatInt(int64(v), 10) + "s")
+	case Int:
+		w.buf.WriteString(strconv.FormatInt(int64(v), 10))
+	case Long:
+		w.buf.WriteString(strconv.FormatInt(int64(v), 10) + "L")
+	case Float:
+		w.buf.WriteString(formatSNBTFloat(float64(v), 32) + "f")
+	case Double:
+		w.buf.WriteString(formatSNBTFloat(float64(v), 64) + "d")
+	case String:
+		w.buf.WriteString(quoteSNBT(string(v)))
+
+	case ByteArray:
+		w.buf.WriteString("[B;")
+		for i, b := range v {
+			w.arraySeparator(i)
+			w.buf.WriteString(strconv.FormatInt(int64(int8(b)), 10) + "b")
+		}
+		w.buf.WriteByte(']')
+	case IntArray:
+		w.buf.WriteString("[I;")
+		for i, n := range v {
+			w.arraySeparator(i)
+			w.buf.WriteString(strconv.FormatInt(int64(n), 10))
+		}
+		w.buf.WriteByte(']')
+	case LongArray:
+		w.buf.WriteString("[L;")
+		for i, n := range v {
+			w.arraySeparator(i)
+			w.buf.WriteString(strconv.FormatInt(n, 10) + "L")
+		}
+		w.buf.WriteByte(']')
+
+	case *List:
+		if v == nil {
+			panic(fmt.Errorf("nbt: Unhandled nil value"))
+		}
+		w.buf.WriteByte('[')
+		inline := fixedSize(v.Elem) != 0
+		for i, elem := range v.Values {
+			if i > 0 {
+				w.buf.WriteByte(',')
+				if inline && w.indent != "" {
+					w.buf.WriteByte(' ')
+				}
+			}
+			if !inline {
+				w.newline(depth + 1)
+			}
+			w.value(elem, depth+1)
+		}
+		if !inline && v.Len() > 0 {
+			w.newline(depth)
+		}
+		w.buf.WriteByte(']')
+
+	case *Compound:
+		if v == nil {
+			panic(fmt.Errorf("nbt: Unhandled nil value"))
+		}
+		names := v.Names()
+		if w.sortKeys {
+			names = append([]string(nil), names...)
+			sort.Strings(names)
+		}
+		w.buf.WriteByte('{')
+		for i, name := range names {
+			if i > 0 {
+				w.buf.WriteByte(',')
+			}
+			w.newline(depth + 1)
+			w.buf.WriteString(snbtKey(name))
+			w.buf.WriteByte(':')
+			if w.indent != "" {
+				w.buf.WriteByte(' ')
+			}
+			w.value(v.Get(name), depth+1)
+		}
+		if len(names) > 0 {
+			w.newline(depth)
+		}
+		w.buf.WriteByte('}')
+
+	default:
+		panic(fmt.Errorf("nbt: Unhandled type: %T", v))
+	}
+}
+
+// Writes the separator before element i of an array: nothing before the first, and a comma
+// before the rest. Indented arrays have a space after the semicolon too.
+func (w *snbtWriter) arraySeparator(i int) {
+	if i > 0 {
+		w.comma()
+	} else if w.indent != "" {
+		w.buf.WriteByte(' ')
+	}
+}
+
+// Formats a float or double so that it always reads back as a decimal number: with a decimal
+// point or an exponent, and in scientific notation only when it is very large or very small.
+func formatSNBTFloat(f float64, bits int) string {
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-3 || abs >= 1e7) {
+		format = 'e'
+	}
+	s := strconv.FormatFloat(f, format, -1, bits)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
+// Quotes a string for SNBT. Double quotes are used unless the string contains double quotes but
+// no single quotes, as Minecraft does.
+func quoteSNBT(s string) string {
+	quote := byte('"')
+	if strings.IndexByte(s, '"') >= 0 && strings.IndexByte(s, '\'') < 0 {
+		quote = '\''
+	}
+
+	b := make([]byte, 0, len(s)+2)
+	b = append(b, quote)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == quote || c == '\\':
+			b = append(b, '\\', c)
+		case c == '\n':
+			b = append(b, '\\', 'n')
+		case c == '\r':
+			b = append(b, '\\', 'r')
+		case c == '\t':
+			b = append(b, '\\', 't')
+		case c < 0x20 || c == 0x7f:
+			b = append(b, fmt.Sprintf("\\x%02x", c)...)
+		default:
+			b = append(b, c)
+		}
+	}
+	return string(append(b, quote))
+}
+
+// Returns a compound key as SNBT, quoted only if it has to be.
+func snbtKey(name string) string {
+	for i := 0; i < len(name); i++ {
+		if !isSNBTWordChar(name[i]) {
+			return quoteSNBT(name)
+		}
+	}
+	if name == "" {
+		return `""`
+	}
+	return name
+}
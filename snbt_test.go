@@ -0,0 +1,304 @@
+package nbt
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// Encodes a tree value, so trees can be compared with bytes.Equal.
+func encodeTree(t *testing.T, v Value) []byte {
+	var buf bytes.Buffer
+	if err := Marshal(Uncompressed, &buf, v); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseSNBT(t *testing.T) {
+	pos := &List{Elem: TAG_Double, Values: []Value{Double(0), Double(64), Double(0)}}
+	tags := &List{Elem: TAG_String, Values: []Value{String("a"), String("b")}}
+	expected := new(Compound)
+	expected.SetFloat("Health", 20)
+	expected.SetList("Tags", tags)
+	expected.SetList("Pos", pos)
+
+	v, err := ParseSNBT(`{Health:20.0f,Tags:["a","b"],Pos:[0.0d,64.0d,0.0d]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encodeTree(t, v), encodeTree(t, expected)) {
+		t.Errorf("Parsed %#v", v)
+	}
+
+	for _, test := range []struct {
+		snbt     string
+		expected Value
+	}{
+		{`1b`, Byte(1)},
+		{`-128B`, Byte(-128)},
+		{`128b`, String("128b")},
+		{`true`, Byte(1)},
+		{`False`, Byte(0)},
+		{`300s`, Short(300)},
+		{`-7`, Int(-7)},
+		{`+7`, Int(7)},
+		{`2147483648`, String("2147483648")},
+		{`01`, String("01")},
+		{`9000000000L`, Long(9000000000)},
+		{`1.5F`, Float(1.5)},
+		{`1f`, Float(1)},
+		{`.5`, Double(0.5)},
+		{`1.`, Double(1)},
+		{`1e3D`, Double(1000)},
+		{`1e3`, String("1e3")},
+		{`1.5e-1`, Double(0.15)},
+		{`minecraft:stone`, nil}, // ':' isn't allowed in an unquoted string.
+		{`minecraft.stone_1`, String("minecraft.stone_1")},
+		{`'it''s'`, nil},
+		{`'say "hi"'`, String(`say "hi"`)},
+		{`"it's \"quoted\" \\ \né"`, String("it's \"quoted\" \\ \né")},
+		{`"bad \q"`, nil},
+		{`[B;1b,-2B,3]`, ByteArray{1, 0xfe, 3}},
+		{`[B;128]`, nil},
+		{`[I; 1, -2 ,3,]`, IntArray{1, -2, 3}},
+		{`[I;]`, IntArray{}},
+		{`[L;1L,2l,3]`, LongArray{1, 2, 3}},
+		{`[L;1.0]`, nil},
+		{`[X;1]`, nil},
+		{`[]`, &List{}},
+		{`[1,2b]`, nil},
+		{`[[],[1]]`, &List{Elem: TAG_List, Values: []Value{&List{}, &List{Elem: TAG_Int, Values: []Value{Int(1)}}}}},
+		{` { "quoted key" : 'v' , 'x-y.z+':{} , } `, func() Value {
+			c := new(Compound)
+			c.SetString("quoted key", "v")
+			c.SetCompound("x-y.z+", new(Compound))
+			return c
+		}()},
+		{`{a:1`, nil},
+		{`{a 1}`, nil},
+		{`{a:1}}`, nil},
+		{``, nil},
+	} {
+		v, err := ParseSNBT(test.snbt)
+		if test.expected == nil {
+			if err == nil {
+				t.Errorf("%s: Parsed as %#v, but expected an error", test.snbt, v)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.snbt, err)
+			continue
+		}
+		if !bytes.Equal(encodeTree(t, v), encodeTree(t, test.expected)) {
+			t.Errorf("%s: Parsed as %#v, but expected %#v", test.snbt, v, test.expected)
+		}
+	}
+}
+
+func TestParseSNBTError(t *testing.T) {
+	_, err := ParseSNBT(`{a:{b:[1,2,"x"]}}`)
+	if err == nil {
+		t.Fatal("No error, but one was expected!")
+	}
+	decodeErr, ok := err.(*DecodeError)
+	if !ok {
+		t.Fatalf("%T is not a *DecodeError", err)
+	}
+	if expected := "nbt: Cannot put a TAG_String (0x08) in a list of TAG_Int (0x03)\n\t\tat list index 2\n\t\tat struct field \"b\"\n\t\tat struct field \"a\""; err.Error() != expected {
+		t.Errorf("Error is %q, but expected %q", err, expected)
+	}
+	if decodeErr.Offset != 14 {
+		t.Errorf("Offset == %d", decodeErr.Offset)
+	}
+}
+
+type SNBTEntity struct {
+	Health float32
+	Tags   []string
+	Pos    [3]float64
+	UUID   []int32
+	Motion []float64 `nbt:",omitempty"`
+	Name   string    `nbt:"CustomName"`
+}
+
+func TestUnmarshalSNBT(t *testing.T) {
+	var entity SNBTEntity
+	err := UnmarshalSNBT(`{Health:20.0f,Tags:["a","b"],Pos:[0.0d,64.0d,0.0d],UUID:[I;1,2,3,4],CustomName:'{"text":"Bob"}'}`, &entity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := SNBTEntity{
+		Health: 20,
+		Tags:   []string{"a", "b"},
+		Pos:    [3]float64{0, 64, 0},
+		UUID:   []int32{1, 2, 3, 4},
+		Name:   `{"text":"Bob"}`,
+	}
+	if !reflect.DeepEqual(entity, expected) {
+		t.Errorf("Decoded %#v", entity)
+	}
+
+	// The same rules as Unmarshal apply: 20 is an Int, which doesn't fit a float32 field.
+	if err := UnmarshalSNBT(`{Health:20}`, &entity); err == nil {
+		t.Errorf("An int was decoded into a float32")
+	}
+}
+
+func TestMarshalSNBT(t *testing.T) {
+	entity := struct {
+		Health float32
+		Tags   []string
+		UUID   []int32 `nbt:",intarray"`
+		Motion []float64
+		Name   string `nbt:"CustomName"`
+	}{
+		Health: 20,
+		Tags:   []string{"a", `say "hi"`},
+		UUID:   []int32{1, -2},
+		Motion: []float64{0.5, 1e8, -0.0001},
+		Name:   "line\nbreak",
+	}
+	snbt, err := MarshalSNBT(entity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{Health:20.0f,Tags:["a",'say "hi"'],UUID:[I;1,-2],Motion:[0.5d,1e+08d,-1e-04d],CustomName:"line\nbreak"}`; snbt != expected {
+		t.Errorf("Wrote %s, but expected %s", snbt, expected)
+	}
+
+	for _, test := range []struct {
+		value    Value
+		expected string
+	}{
+		{Byte(-1), `-1b`},
+		{Short(300), `300s`},
+		{Long(-9000000000), `-9000000000L`},
+		{Float(1.1), `1.1f`},
+		{Double(100), `100.0d`},
+		{String(`it's "both"`), `"it's \"both\""`},
+		{String("\\\x01"), `"\\\x01"`},
+		{ByteArray{1, 0xff}, `[B;1b,-1b]`},
+		{IntArray{}, `[I;]`},
+		{LongArray{5}, `[L;5L]`},
+		{&List{}, `[]`},
+		{func() Value {
+			c := new(Compound)
+			c.SetInt("a b", 1)
+			c.SetInt("", 2)
+			c.SetInt("c:d", 3)
+			c.SetInt("x.y-z", 4)
+			return c
+		}(), `{"a b":1,"":2,"c:d":3,x.y-z:4}`},
+	} {
+		snbt, err := MarshalSNBT(test.value)
+		if err != nil {
+			t.Errorf("%#v: %v", test.value, err)
+			continue
+		}
+		if snbt != test.expected {
+			t.Errorf("%#v: Wrote %s, but expected %s", test.value, snbt, test.expected)
+		}
+	}
+
+	if _, err := MarshalSNBT((*Compound)(nil)); err == nil {
+		t.Errorf("A nil compound was written")
+	}
+}
+
+func TestSNBTEncoderIndent(t *testing.T) {
+	root := new(Compound)
+	root.SetString("name", "Bob")
+	root.SetList("pos", &List{Elem: TAG_Double, Values: []Value{Double(0), Double(64)}})
+	root.SetCompound("empty", new(Compound))
+	root.SetList("items", &List{Elem: TAG_Compound, Values: []Value{func() Value {
+		c := new(Compound)
+		c.SetString("id", "minecraft:stone")
+		c.SetByte("Count", 1)
+		return c
+	}()}})
+	root.SetIntArray("uuid", IntArray{1, 2})
+
+	var buf bytes.Buffer
+	enc := NewSNBTEncoder(&buf)
+	enc.SetIndent("  ")
+	enc.SortKeys()
+	if err := enc.Encode(root); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{
+  empty: {},
+  items: [
+    {
+      Count: 1b,
+      id: "minecraft:stone"
+    }
+  ],
+  name: "Bob",
+  pos: [0.0d, 64.0d],
+  uuid: [I; 1, 2]
+}
+`
+	if buf.String() != expected {
+		t.Errorf("Wrote\n%s\nbut expected\n%s", buf.String(), expected)
+	}
+
+	// Sorting doesn't change the compound itself.
+	if names := root.Names(); names[0] != "name" {
+		t.Errorf("Names() == %q", names)
+	}
+}
+
+func TestSNBTRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		compression Compression
+	}{
+		{"servers.dat", Uncompressed},
+		{"bigtest.nbt", GZip},
+		{"Nightgunner5.dat", GZip},
+		{"longarraytest.nbt", GZip},
+	} {
+		var root Value
+		if err := Unmarshal(Uncompressed, bytes.NewReader(readTestcase(t, test.name, test.compression)), &root); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		for _, indent := range []string{"", "\t"} {
+			var buf bytes.Buffer
+			enc := NewSNBTEncoder(&buf)
+			enc.SetIndent(indent)
+			if err := enc.Encode(root); err != nil {
+				t.Errorf("%s: %v", test.name, err)
+				continue
+			}
+
+			parsed, err := ParseSNBT(buf.String())
+			if err != nil {
+				t.Errorf("%s: %v", test.name, err)
+				continue
+			}
+			if !bytes.Equal(encodeTree(t, parsed), encodeTree(t, root)) {
+				t.Errorf("%s: SNBT round trip with indent %q differs from the original", test.name, indent)
+			}
+		}
+	}
+}
+
+func TestSNBTEncodeAll(t *testing.T) {
+	var stream bytes.Buffer
+	enc := NewEncoder(&stream)
+	enc.Encode(map[string]int32{"a": 1})
+	enc.Encode(int16(2))
+	enc.Flush()
+
+	var buf bytes.Buffer
+	if err := NewSNBTEncoder(&buf).EncodeAll(NewDecoder(&stream)); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "{a:1}\n2s\n"; buf.String() != expected {
+		t.Errorf("Wrote %q, but expected %q", buf.String(), expected)
+	}
+}
@@ -0,0 +1,322 @@
+package nbt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// A Decoder reads a sequence of NBT root tags from an input stream. Unlike Unmarshal, the
+// decompressor is only created once, so concatenated roots in a single compressed stream can
+// be read one after another.
+//
+// The Decoder may read more data from the input stream than the values it returns need.
+type Decoder struct {
+	raw *bufio.Reader
+	in  *bufio.Reader
+
+	compression  Compression
+	d            decodeState
+	maxLengthSet bool // Whether SetMaxLength was called, so UseNetworkFormat keeps its limit.
+
+	err error
+}
+
+// The maximum nesting depth used by a new Decoder. This is the same limit Minecraft uses.
+const DefaultMaxDepth = 512
+
+// The maximum length of strings, lists and arrays set by UseNetworkFormat, since network
+// payloads usually come from untrusted clients.
+const DefaultNetworkMaxLength = 1 << 20
+
+// Creates a Decoder reading uncompressed NBT from in. Use SetCompression before the first call to
+// Decode or More to read compressed data.
+func NewDecoder(in io.Reader) *Decoder {
+	if in == nil {
+		panic(fmt.Errorf("nbt: Input stream is nil"))
+	}
+
+	dec := &Decoder{raw: bufio.NewReader(in)}
+	dec.d.maxDepth = DefaultMaxDepth
+	dec.d.order = binary.BigEndian
+	return dec
+}
+
+// Sets the compression of the input stream. The whole stream is decompressed as one unit, so
+// multiple roots must share a single gzip or zlib stream. (Concatenated gzip members also work.)
+func (dec *Decoder) SetCompression(compression Compression) {
+	if dec.in != nil {
+		panic(fmt.Errorf("nbt: SetCompression called after decoding started"))
+	}
+	dec.compression = compression
+}
+
+// Sets the byte order of numbers and lengths in the input. The default is binary.BigEndian, as
+// used by Java Edition; Bedrock Edition uses binary.LittleEndian.
+func (dec *Decoder) SetByteOrder(order binary.ByteOrder) {
+	dec.d.order = order
+}
+
+// Reads the variant of NBT used by the network protocol of Bedrock Edition. It is little-endian,
+// with ints and longs written as zig-zag varints and the lengths of strings, lists and arrays as
+// varints too. Unless SetMaxLength was called, lengths are limited to DefaultNetworkMaxLength.
+func (dec *Decoder) UseNetworkFormat() {
+	dec.d.order = binary.LittleEndian
+	dec.d.varint = true
+	if !dec.maxLengthSet {
+		dec.d.maxLength = DefaultNetworkMaxLength
+	}
+}
+
+// Reads root tags without a name, as sent by the network protocol of Java Edition since 1.20.2
+// (protocol 764). A root of TAG_End on its own means the value is absent, and sets the value
+// Decode is given to its zero value, such as nil.
+func (dec *Decoder) UseNamelessRoot() {
+	dec.d.nameless = true
+}
+
+// Limits how deeply lists and compounds may be nested. A depth of 0 removes the limit.
+func (dec *Decoder) SetMaxDepth(depth int) {
+	dec.d.maxDepth = depth
+}
+
+// Limits the number of elements in a single list, byte array or int array, and the length of
+// varint strings. This stops corrupt input early. A length of 0 removes the limit, which is the
+// default except with UseNetworkFormat.
+func (dec *Decoder) SetMaxLength(length int) {
+	dec.d.maxLength = length
+	dec.maxLengthSet = true
+}
+
+// Makes Decode report an error for compound entries that have no matching struct field. By
+// default, they are skipped without being decoded.
+func (dec *Decoder) DisallowUnknownFields() {
+	dec.d.disallowUnknownFields = true
+}
+
+// Allows numeric tags to be decoded into Go types of a different size or signedness, such as a
+// TAG_Short into an int32 or a TAG_Int into a float64, as long as the value fits. By default, the
+// Go type must match the tag exactly.
+func (dec *Decoder) AllowNumericConversion() {
+	dec.d.convert = true
+}
+
+func (dec *Decoder) start() {
+	if dec.in != nil {
+		return
+	}
+
+	dec.d.init(dec.compression, dec.raw)
+	if in, ok := dec.d.in.(*bufio.Reader); ok {
+		dec.in = in
+	} else {
+		dec.in = bufio.NewReader(dec.d.in)
+		dec.d.in = dec.in
+	}
+}
+
+// Reports whether there is another root tag in the input stream.
+func (dec *Decoder) More() bool {
+	if dec.err != nil {
+		return true
+	}
+
+	if dec.in == nil {
+		if _, err := dec.raw.Peek(1); err != nil {
+			return false
+		}
+
+		defer func() {
+			if r := recover(); r != nil {
+				dec.err = dec.d.error(r)
+			}
+		}()
+		dec.start()
+	}
+
+	_, err := dec.in.Peek(1)
+	return err == nil
+}
+
+// Reads the next root tag from the input stream and stores it in the value pointed to by v.
+// After an error, the Decoder is left in an unknown position and every later call to Decode
+// returns the same error.
+func (dec *Decoder) Decode(v interface{}) error {
+	_, err := dec.DecodeNamed(v)
+	return err
+}
+
+// Like Decode, but also returns the name of the root tag, which is always empty with
+// UseNamelessRoot. If v points to a struct with a field tagged `nbt:",rootname"`, the name is
+// stored there too.
+func (dec *Decoder) DecodeNamed(v interface{}) (name string, err error) {
+	if dec.err != nil {
+		return "", dec.err
+	}
+
+	start := dec.d.offset
+	defer func() {
+		if r := recover(); r != nil {
+			if r == io.EOF && dec.d.offset == start {
+				// There was nothing left to read, which is not an error in the stream itself.
+				err = io.EOF
+			} else {
+				err = dec.d.error(r)
+			}
+			dec.err = err
+		}
+	}()
+
+	if rv := reflect.ValueOf(v); rv.Kind() != reflect.Ptr || rv.IsNil() {
+		panic(fmt.Errorf("nbt: Decode requires a non-nil pointer, not %v", reflect.TypeOf(v)))
+	}
+
+	dec.start()
+	dec.d.depth = 0
+	name = dec.d.unmarshal(v)
+	return
+}
+
+// An Encoder writes a sequence of NBT root tags to an output stream. All roots written by an
+// Encoder share a single compressed stream.
+//
+// Output is buffered, so Flush or Close must be called after the last call to Encode.
+type Encoder struct {
+	out io.Writer
+	buf *bufio.Writer
+	c   compressor
+
+	compression Compression
+	level       int
+	order       binary.ByteOrder
+	varint      bool
+	nameless    bool
+	rootName    string
+
+	err error
+}
+
+// Creates an Encoder writing uncompressed NBT to out. Use SetCompression before the first call to
+// Encode to write compressed data.
+func NewEncoder(out io.Writer) *Encoder {
+	if out == nil {
+		panic(fmt.Errorf("nbt: Output stream is nil"))
+	}
+
+	return &Encoder{out: out, level: DefaultCompression, order: binary.BigEndian}
+}
+
+// Sets the compression of the output stream.
+func (enc *Encoder) SetCompression(compression Compression) {
+	if enc.buf != nil {
+		panic(fmt.Errorf("nbt: SetCompression called after encoding started"))
+	}
+	enc.compression = compression
+}
+
+// Sets the compression level, such as BestSpeed or BestCompression. LZ4 ignores it.
+func (enc *Encoder) SetCompressionLevel(level int) {
+	if enc.buf != nil {
+		panic(fmt.Errorf("nbt: SetCompressionLevel called after encoding started"))
+	}
+	enc.level = level
+}
+
+// Sets the byte order of numbers and lengths in the output. The default is binary.BigEndian, as
+// used by Java Edition; Bedrock Edition uses binary.LittleEndian.
+func (enc *Encoder) SetByteOrder(order binary.ByteOrder) {
+	enc.order = order
+}
+
+// Writes the variant of NBT used by the network protocol of Bedrock Edition, as described for
+// Decoder.UseNetworkFormat.
+func (enc *Encoder) UseNetworkFormat() {
+	enc.order = binary.LittleEndian
+	enc.varint = true
+}
+
+// Writes root tags without a name, as the network protocol of Java Edition does since 1.20.2
+// (protocol 764). Any name set with SetRootName is ignored. A nil value, such as a nil pointer,
+// is written as a lone TAG_End, which means the value is absent.
+func (enc *Encoder) UseNamelessRoot() {
+	enc.nameless = true
+}
+
+// Sets the name written for each root tag. If it is empty, which is the default, the name comes
+// from a field of the value tagged `nbt:",rootname"`, if it has one.
+func (enc *Encoder) SetRootName(name string) {
+	enc.rootName = name
+}
+
+func (enc *Encoder) start() {
+	if enc.buf != nil {
+		return
+	}
+
+	enc.c = compress(enc.compression, enc.level, enc.out)
+	if enc.c != nil {
+		enc.buf = bufio.NewWriter(enc.c)
+	} else {
+		enc.buf = bufio.NewWriter(enc.out)
+	}
+}
+
+func (enc *Encoder) catch(err *error) {
+	if r := recover(); r != nil {
+		*err = encodeError(r)
+		enc.err = *err
+	}
+}
+
+// Writes v as the next root tag. After an error, the output stream contains a partial tag and
+// every later call to the Encoder returns the same error.
+func (enc *Encoder) Encode(v interface{}) (err error) {
+	if enc.err != nil {
+		return enc.err
+	}
+	defer enc.catch(&err)
+
+	enc.start()
+	e := newEncodeState(enc.buf)
+	e.order, e.varint, e.nameless = enc.order, enc.varint, enc.nameless
+	e.writeRootTag(enc.rootName, reflect.ValueOf(v))
+	return
+}
+
+// Writes any buffered data, including data held by the compressor, to the output stream. The
+// compressed stream stays open, so more roots can be encoded afterwards.
+func (enc *Encoder) Flush() (err error) {
+	if enc.err != nil {
+		return enc.err
+	}
+	defer enc.catch(&err)
+
+	enc.start()
+	if err := enc.buf.Flush(); err != nil {
+		panic(err)
+	}
+	if enc.c != nil {
+		if err := enc.c.Flush(); err != nil {
+			panic(err)
+		}
+	}
+	return
+}
+
+// Flushes the Encoder and finishes the compressed stream. The underlying writer is not closed.
+func (enc *Encoder) Close() (err error) {
+	if err = enc.Flush(); err != nil {
+		return
+	}
+	defer enc.catch(&err)
+
+	if enc.c != nil {
+		if err := enc.c.Close(); err != nil {
+			panic(err)
+		}
+	}
+	enc.err = fmt.Errorf("nbt: Encoder is closed")
+	return
+}
@@ -0,0 +1,298 @@
+package nbt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestDecoderMultipleRoots(t *testing.T) {
+	data, err := ioutil.ReadFile("testcases/servers.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	for i := 0; i < 3; i++ {
+		gz.Write(data)
+	}
+	gz.Close()
+
+	dec := NewDecoder(&compressed)
+	dec.SetCompression(GZip)
+
+	count := 0
+	for dec.More() {
+		var list ServerList
+		if err := dec.Decode(&list); err != nil {
+			t.Fatal(err)
+		}
+		if len(list.Servers) != 3 {
+			t.Errorf("Server list length is %d, but expected 3.", len(list.Servers))
+		}
+		assertString(t, "Servers[2].IP", list.Servers[2].IP, "snow.man")
+		count++
+	}
+	if count != 3 {
+		t.Errorf("Decoded %d roots, but expected 3.", count)
+	}
+
+	var list ServerList
+	if err := dec.Decode(&list); err != io.EOF {
+		t.Errorf("Expected io.EOF after the last root, but got %v", err)
+	}
+}
+
+func TestDecoderEmpty(t *testing.T) {
+	dec := NewDecoder(bytes.NewReader(nil))
+	dec.SetCompression(GZip)
+	if dec.More() {
+		t.Error("More returned true for an empty stream")
+	}
+}
+
+func TestDecoderMaxLength(t *testing.T) {
+	f, err := ioutil.ReadFile("testcases/servers.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dec := NewDecoder(bytes.NewReader(f))
+	dec.SetMaxLength(2)
+
+	var list ServerList
+	err = dec.Decode(&list)
+	if err == nil {
+		t.Error("No error, but one was expected!")
+	} else if err.Error() != "nbt: TAG_List (0x09) is of length 3, which exceeds the limit of 2\n\t\tat struct field \"servers\"" {
+		t.Error(err)
+	}
+}
+
+type Narrow struct {
+	A int16
+	B float32
+	C int8
+}
+
+type Wide struct {
+	A int64
+	B float64
+	C uint32
+}
+
+func TestDecoderNumericConversion(t *testing.T) {
+	var buf bytes.Buffer
+	err := Marshal(Uncompressed, &buf, Narrow{A: -300, B: 0.5, C: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	var wide Wide
+	err = NewDecoder(bytes.NewReader(data)).Decode(&wide)
+	if err == nil {
+		t.Error("No error, but one was expected!")
+	}
+
+	dec := NewDecoder(bytes.NewReader(data))
+	dec.AllowNumericConversion()
+	if err = dec.Decode(&wide); err != nil {
+		t.Fatal(err)
+	}
+	if wide.A != -300 || wide.B != 0.5 || wide.C != 100 {
+		t.Errorf("Decoded %#v", wide)
+	}
+
+	var overflow struct {
+		A int8
+		B float64
+		C uint32
+	}
+	dec = NewDecoder(bytes.NewReader(data))
+	dec.AllowNumericConversion()
+	err = dec.Decode(&overflow)
+	if err == nil {
+		t.Error("No error, but one was expected!")
+	} else if err.Error() != "nbt: Value -300 of TAG_Short (0x02) overflows a int8\n\t\tat struct field \"A\"" {
+		t.Error(err)
+	}
+}
+
+func TestEncoderMultipleRoots(t *testing.T) {
+	var buf bytes.Buffer
+
+	enc := NewEncoder(&buf)
+	enc.SetCompression(ZLib)
+	enc.SetCompressionLevel(BestCompression)
+	for _, name := range []string{"a", "b", "c"} {
+		if err := enc.Encode(ServerList{[]Server{{Name: name, IP: "localhost"}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Encode(ServerList{}); err == nil {
+		t.Error("Encode after Close did not return an error")
+	}
+
+	dec := NewDecoder(&buf)
+	dec.SetCompression(ZLib)
+	var names []string
+	for dec.More() {
+		var list ServerList
+		if err := dec.Decode(&list); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, list.Servers[0].Name)
+	}
+	if len(names) != 3 || names[0] != "a" || names[1] != "b" || names[2] != "c" {
+		t.Errorf("Decoded names %v, but expected [a b c]", names)
+	}
+}
+
+func TestEncoderRootName(t *testing.T) {
+	var buf bytes.Buffer
+
+	enc := NewEncoder(&buf)
+	enc.SetRootName("Level")
+	if err := enc.Encode(struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte{byte(TAG_Compound), 0, 5, 'L', 'e', 'v', 'e', 'l', byte(TAG_End)}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("Encoded % x, but expected % x", buf.Bytes(), expected)
+	}
+}
+
+type NetworkItem struct {
+	ID    string `nbt:"id"`
+	Count int8
+}
+
+func TestNamelessRoot(t *testing.T) {
+	var buf bytes.Buffer
+
+	enc := NewEncoder(&buf)
+	enc.UseNamelessRoot()
+	enc.SetRootName("ignored")
+	for _, v := range []interface{}{
+		NetworkItem{ID: "minecraft:stone", Count: 64},
+		(*NetworkItem)(nil),
+		nil,
+		int32(7),
+	} {
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+	if expected := []byte{byte(TAG_Compound), byte(TAG_String), 0, 2, 'i', 'd'}; !bytes.HasPrefix(data, expected) {
+		t.Errorf("Encoded % x, but expected it to start with % x", data, expected)
+	}
+	if expected := []byte{byte(TAG_End), byte(TAG_End), byte(TAG_Int), 0, 0, 0, 7}; !bytes.HasSuffix(data, expected) {
+		t.Errorf("Encoded % x, but expected it to end with % x", data, expected)
+	}
+
+	dec := NewDecoder(bytes.NewReader(data))
+	dec.UseNamelessRoot()
+	var item *NetworkItem
+	if err := dec.Decode(&item); err != nil {
+		t.Fatal(err)
+	}
+	if item == nil || *item != (NetworkItem{ID: "minecraft:stone", Count: 64}) {
+		t.Errorf("Decoded %#v", item)
+	}
+	if err := dec.Decode(&item); err != nil {
+		t.Fatal(err)
+	}
+	if item != nil {
+		t.Errorf("TAG_End was decoded as %#v", item)
+	}
+	tree := new(Compound)
+	if err := dec.Decode(&tree); err != nil {
+		t.Fatal(err)
+	}
+	if tree != nil {
+		t.Errorf("TAG_End was decoded as %#v", tree)
+	}
+	var i int32
+	if err := dec.Decode(&i); err != nil || i != 7 {
+		t.Errorf("Decoded %d, %v", i, err)
+	}
+	if dec.More() {
+		t.Errorf("Data left over")
+	}
+}
+
+func TestDecoderUnknownFields(t *testing.T) {
+	data, err := ioutil.ReadFile("testcases/servers.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dec := NewDecoder(bytes.NewReader(data))
+	var empty EmptyServerList
+	if err := dec.Decode(&empty); err != nil {
+		t.Error(err)
+	}
+	if dec.More() {
+		t.Error("The skipped list was not read completely")
+	}
+
+	dec = NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&empty)
+	if err == nil {
+		t.Error("No error, but one was expected!")
+	} else if err.Error() != "nbt: Unhandled TAG_List (0x09)\n\t\tat struct field \"servers\"" {
+		t.Error(err)
+	}
+}
+
+type PartialBigTest struct {
+	StringTest string `nbt:"stringTest"`
+	Nested     struct {
+		Egg struct {
+			Value float32 `nbt:"value"`
+		} `nbt:"egg"`
+	} `nbt:"nested compound test"`
+}
+
+func TestDecoderSkipsEveryTag(t *testing.T) {
+	for _, name := range []string{"bigtest.nbt", "longarraytest.nbt", "Nightgunner5.dat"} {
+		f, err := os.Open("testcases/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dec := NewDecoder(f)
+		dec.SetCompression(GZip)
+
+		var partial PartialBigTest
+		if err := dec.Decode(&partial); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if dec.More() {
+			t.Errorf("%s: The skipped tags were not read completely", name)
+		}
+		f.Close()
+
+		if name == "bigtest.nbt" && (partial.StringTest != "HELLO WORLD THIS IS A TEST STRING ÅÄÖ!" || partial.Nested.Egg.Value != 0.5) {
+			t.Errorf("%s: Decoded %#v", name, partial)
+		}
+	}
+}
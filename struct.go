@@ -3,32 +3,391 @@ package nbt
 import (
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
 )
 
-func parseStruct(v reflect.Value) map[string]reflect.Value {
-	parsed := make(map[string]reflect.Value)
-	t := v.Type()
+// A structField describes how a struct field is encoded.
+type structField struct {
+	name      string
+	index     []int // The field index sequence, as for reflect.Value.FieldByIndex.
+	tagged    bool  // Whether the name came from the struct tag.
+	omitEmpty bool
+
+	as      Tag  // The tag chosen with an option like "intarray", or TAG_End for the default.
+	elem    Tag  // The element tag of a list chosen with an option like "short", or TAG_End.
+	convert bool // Whether the field's numbers may be stored in Go types that don't match the tag.
+}
+
+type structInfo struct {
+	fields []structField
+	byName map[string]int // Indexes into fields.
+	rest   []int          // The index sequence of the field tagged with the "rest" option, or nil.
+
+	rootName    []int  // The index sequence of the field tagged with the "rootname" option, or nil.
+	defaultRoot string // The name in the struct tag of the rootname field.
+}
+
+// Collects the fields of a struct type; use getStructInfo, which caches the result. The fields of
+// embedded structs without a name in their struct tag are flattened into the parent, following
+// the rules of encoding/json: a shallower field hides deeper ones with the same name, a tagged
+// field beats untagged ones at the same depth, and other conflicts below the top level hide every
+// field involved.
+func newStructInfo(t reflect.Type) *structInfo {
+	info := new(structInfo)
+
+	type embedded struct {
+		t     reflect.Type
+		index []int
+	}
+	current := []embedded{{t: t}}
+	visited := make(map[reflect.Type]bool)
+	hidden := make(map[string]bool)
+
+	// The number of times each embedded struct type was reached at the current and next depth.
+	// A type reached through several parents is only walked once, but its fields count as
+	// conflicting copies, as in encoding/json.
+	var types, nextTypes map[reflect.Type]int
+
+	for depth := 0; len(current) > 0; depth++ {
+		var next []embedded
+		var found []structField
+		count := make(map[string]int)
+		types, nextTypes = nextTypes, make(map[reflect.Type]int)
+
+		for _, e := range current {
+			if visited[e.t] {
+				continue
+			}
+			visited[e.t] = true
+
+			for i := 0; i < e.t.NumField(); i++ {
+				f := e.t.Field(i)
+				index := make([]int, len(e.index)+1)
+				copy(index, e.index)
+				index[len(e.index)] = i
+
+				name, opts := parseTag(f.Tag.Get("nbt"))
+				if name == "-" {
+					continue
+				}
+				if opts.Contains("rest") {
+					if info.rest == nil {
+						info.rest = index
+					}
+					continue
+				}
+				if opts.Contains("rootname") {
+					if f.Type.Kind() != reflect.String {
+						panic(fmt.Errorf("nbt: The rootname field %s of %v is not a string", f.Name, t))
+					}
+					if info.rootName == nil {
+						info.rootName, info.defaultRoot = index, name
+					}
+					continue
+				}
+				if f.Anonymous && name == "" {
+					if ft := indirectType(f.Type); ft.Kind() == reflect.Struct {
+						// Embedded pointers to unexported types can't be allocated.
+						if f.PkgPath == "" || f.Type.Kind() != reflect.Ptr {
+							nextTypes[ft]++
+							if nextTypes[ft] == 1 {
+								next = append(next, embedded{t: ft, index: index})
+							}
+						}
+						continue
+					}
+					if f.PkgPath != "" {
+						continue
+					}
+				}
+
+				field := structField{
+					name:      name,
+					index:     index,
+					tagged:    name != "",
+					omitEmpty: opts.Contains("omitempty"),
+				}
+				if name == "" {
+					field.name = f.Name
+				}
+				for _, opt := range opts {
+					tag, ok := tagTypeOptions[opt]
+					if !ok {
+						continue
+					}
+					if k := indirectType(f.Type).Kind(); fixedSize(tag) != 0 && (k == reflect.Slice || k == reflect.Array) {
+						field.as, field.elem = TAG_List, tag
+					} else if tag != TAG_List || field.as != TAG_List {
+						// "list" keeps an element tag chosen before it, so the order of options doesn't matter.
+						field.as, field.elem = tag, TAG_End
+					}
+				}
+				field.convert = field.as != TAG_End && isNumberType(f.Type)
+				found = append(found, field)
+				count[field.name]++
+				if types[e.t] > 1 {
+					found = append(found, field)
+					count[field.name]++
+				}
+			}
+		}
+
+		for _, field := range found {
+			if hidden[field.name] {
+				continue
+			}
+			if count[field.name] > 1 {
+				if depth == 0 {
+					panic(fmt.Errorf("Multiple fields with name %#v", field.name))
+				}
+				if !dominantField(found, field) {
+					continue
+				}
+			}
+			info.fields = append(info.fields, field)
+		}
+		// Names used at this depth hide deeper fields, even if a conflict left them unused.
+		for name := range count {
+			hidden[name] = true
+		}
+
+		current = next
+	}
 
-	for i := 0; i < t.NumField(); i++ {
-		f := t.Field(i)
-		if f.Anonymous {
-			continue
+	// Fields are encoded in the order they appear in the source, as if embedded structs were
+	// written out in place.
+	sort.Sort(byIndex(info.fields))
+	info.byName = make(map[string]int, len(info.fields))
+	for i, field := range info.fields {
+		info.byName[field.name] = i
+	}
+
+	return info
+}
+
+// Reports whether field is the only tagged field with its name in fields.
+func dominantField(fields []structField, field structField) bool {
+	if !field.tagged {
+		return false
+	}
+	tagged := 0
+	for _, f := range fields {
+		if f.name == field.name && f.tagged {
+			tagged++
 		}
+	}
+	return tagged == 1
+}
+
+type byIndex []structField
 
-		name := f.Name
-		if tag := f.Tag.Get("nbt"); tag != "" {
-			name = tag
+func (x byIndex) Len() int      { return len(x) }
+func (x byIndex) Swap(i, j int) { x[i], x[j] = x[j], x[i] }
+func (x byIndex) Less(i, j int) bool {
+	for k, xik := range x[i].index {
+		if k >= len(x[j].index) {
+			return false
+		}
+		if xik != x[j].index[k] {
+			return xik < x[j].index[k]
 		}
-		if name == "-" {
-			continue
+	}
+	return len(x[i].index) < len(x[j].index)
+}
+
+// Returns the field of v with the given index sequence, allocating nil embedded pointers on the
+// way.
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
 		}
+		v = v.Field(x)
+	}
+	return v
+}
 
-		if _, exists := parsed[name]; exists {
-			panic(fmt.Errorf("Multiple fields with name %#v", name))
+// Returns the field of v with the given index sequence, or false if it is inside a nil embedded
+// pointer.
+func lookupField(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
 		}
+		v = v.Field(x)
+	}
+	return v, true
+}
 
-		parsed[name] = reflect.Indirect(v.Field(i))
+func parseStruct(v reflect.Value) map[string]reflect.Value {
+	parsed := make(map[string]reflect.Value)
+
+	for _, f := range getStructInfo(v.Type()).fields {
+		if field, ok := lookupField(v, f.index); ok {
+			parsed[f.name] = field
+		}
 	}
 
 	return parsed
 }
+
+// Returns the field tagged with the "rest" option, which holds every compound entry without a
+// field of its own.
+func restField(v reflect.Value) (reflect.Value, bool) {
+	if index := getStructInfo(v.Type()).rest; index != nil {
+		return lookupField(v, index)
+	}
+	return reflect.Value{}, false
+}
+
+// Returns the struct a root value stands for, following pointers and interfaces, or false if
+// it isn't a struct.
+func rootStruct(v reflect.Value) (reflect.Value, bool) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}, false
+		}
+		v = v.Elem()
+	}
+	return v, v.Kind() == reflect.Struct
+}
+
+// Returns the name to write for a root value that was given no name: the value of its rootname
+// field, or failing that the name in the field's struct tag.
+func rootNameOf(v reflect.Value) string {
+	v, ok := rootStruct(v)
+	if !ok {
+		return ""
+	}
+	info := getStructInfo(v.Type())
+	if info.rootName == nil {
+		return ""
+	}
+	if field, ok := lookupField(v, info.rootName); ok && field.String() != "" {
+		return field.String()
+	}
+	return info.defaultRoot
+}
+
+// Stores the name of the root tag in the rootname field of a decoded root value, if it has one.
+func setRootName(v reflect.Value, name string) {
+	v, ok := rootStruct(v)
+	if !ok || !v.CanSet() {
+		return
+	}
+	if index := getStructInfo(v.Type()).rootName; index != nil {
+		if field := fieldByIndex(v, index); field.CanSet() {
+			field.SetString(name)
+		}
+	}
+}
+
+// Reports whether v is a nil pointer, map or interface. These are never encoded.
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+// Reports whether v is empty for the "omitempty" option, as in encoding/json.
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// Reports whether t, after any pointers, is a number or bool, or a slice or array of them.
+func isNumberType(t reflect.Type) bool {
+	t = indirectType(t)
+	if k := t.Kind(); k == reflect.Slice || k == reflect.Array {
+		t = indirectType(t.Elem())
+	}
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// Options that choose the tag a field is encoded as. Numeric tags on a slice or array field choose
+// the element tag of a list.
+var tagTypeOptions = map[string]Tag{
+	"byte":      TAG_Byte,
+	"short":     TAG_Short,
+	"int":       TAG_Int,
+	"long":      TAG_Long,
+	"float":     TAG_Float,
+	"double":    TAG_Double,
+	"bytearray": TAG_Byte_Array,
+	"list":      TAG_List,
+	"intarray":  TAG_Int_Array,
+	"longarray": TAG_Long_Array,
+}
+
+// The options that may follow the name in a struct tag.
+var knownTagOptions = map[string]bool{
+	"rest":      true,
+	"omitempty": true,
+	"rootname":  true,
+}
+
+func init() {
+	for opt := range tagTypeOptions {
+		knownTagOptions[opt] = true
+	}
+}
+
+type tagOptions []string
+
+// Splits a struct tag into a name and options, like `nbt:"name,option"`. NBT names may contain
+// commas, so only known options are split off the end.
+func parseTag(tag string) (string, tagOptions) {
+	var opts tagOptions
+	for {
+		i := strings.LastIndex(tag, ",")
+		if i < 0 || !knownTagOptions[tag[i+1:]] {
+			return tag, opts
+		}
+		opts = append(opts, tag[i+1:])
+		tag = tag[:i]
+	}
+}
+
+func (opts tagOptions) Contains(option string) bool {
+	for _, opt := range opts {
+		if opt == option {
+			return true
+		}
+	}
+	return false
+}
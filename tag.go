@@ -19,6 +19,7 @@ const (
 	TAG_List       Tag = 9  // tagID TAG_Byte, length TAG_Int, then payload [length]tagID.
 	TAG_Compound   Tag = 10 // { tagID TAG_Byte, name TAG_String, payload tagID }... TAG_End
 	TAG_Int_Array  Tag = 11 // size TAG_Int, then payload [size]TAG_Int
+	TAG_Long_Array Tag = 12 // size TAG_Int, then payload [size]TAG_Long
 )
 
 func (tag Tag) String() string {
@@ -48,14 +49,36 @@ func (tag Tag) String() string {
 		name = "TAG_Compound"
 	case TAG_Int_Array:
 		name = "TAG_Int_Array"
+	case TAG_Long_Array:
+		name = "TAG_Long_Array"
 	}
 	return fmt.Sprintf("%s (0x%02x)", name, byte(tag))
 }
 
+// Returns the tag of the elements of an array tag, or TAG_End for other tags.
+func arrayElem(tag Tag) Tag {
+	switch tag {
+	case TAG_Byte_Array:
+		return TAG_Byte
+	case TAG_Int_Array:
+		return TAG_Int
+	case TAG_Long_Array:
+		return TAG_Long
+	}
+	return TAG_End
+}
+
 type Compression byte
 
 const (
 	Uncompressed Compression = 0
 	GZip         Compression = 1
 	ZLib         Compression = 2
+	Deflate      Compression = 3 // Raw DEFLATE (RFC 1951) without a gzip or zlib header.
+	LZ4          Compression = 4 // The LZ4 block stream of lz4-java, as in Minecraft 1.20.5+ region files.
+
+	// Only for decoding: looks at the first bytes of the input to tell gzip and zlib streams from
+	// uncompressed NBT. Unless the input is a *bufio.Reader, it is buffered, so more of it may be
+	// read than the NBT data needs.
+	AutoDetect Compression = 255
 )
@@ -0,0 +1,480 @@
+package nbt
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+)
+
+// A Value is an NBT payload that remembers its exact tag type. Decoding into a Value (or into one
+// of the types implementing it) keeps everything needed to encode the same bytes again: integer
+// sizes, the element type of empty lists and the order of compound entries.
+type Value interface {
+	Tag() Tag
+}
+
+type (
+	Byte      int8
+	Short     int16
+	Int       int32
+	Long      int64
+	Float     float32
+	Double    float64
+	ByteArray []byte
+	String    string
+	IntArray  []int32
+	LongArray []int64
+)
+
+func (Byte) Tag() Tag      { return TAG_Byte }
+func (Short) Tag() Tag     { return TAG_Short }
+func (Int) Tag() Tag       { return TAG_Int }
+func (Long) Tag() Tag      { return TAG_Long }
+func (Float) Tag() Tag     { return TAG_Float }
+func (Double) Tag() Tag    { return TAG_Double }
+func (ByteArray) Tag() Tag { return TAG_Byte_Array }
+func (String) Tag() Tag    { return TAG_String }
+func (IntArray) Tag() Tag  { return TAG_Int_Array }
+func (LongArray) Tag() Tag { return TAG_Long_Array }
+func (*List) Tag() Tag     { return TAG_List }
+func (*Compound) Tag() Tag { return TAG_Compound }
+
+// A List is a TAG_List. Every value must have the tag Elem. Minecraft writes empty lists with an
+// Elem of TAG_End.
+type List struct {
+	Elem   Tag
+	Values []Value
+}
+
+// Returns the number of values in the list.
+func (l *List) Len() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.Values)
+}
+
+// Returns the value at index i.
+func (l *List) Get(i int) Value {
+	return l.Values[i]
+}
+
+// Replaces the value at index i. The value must have the list's element tag.
+func (l *List) Set(i int, value Value) error {
+	if value.Tag() != l.Elem {
+		return fmt.Errorf("nbt: Cannot put a %s in a list of %s", value.Tag(), l.Elem)
+	}
+	l.Values[i] = value
+	return nil
+}
+
+// Adds values to the end of the list. If the list is empty and has an element type of TAG_End, the
+// element type is taken from the first value.
+func (l *List) Append(values ...Value) error {
+	for _, value := range values {
+		if len(l.Values) == 0 && l.Elem == TAG_End {
+			l.Elem = value.Tag()
+		}
+		if value.Tag() != l.Elem {
+			return fmt.Errorf("nbt: Cannot put a %s in a list of %s", value.Tag(), l.Elem)
+		}
+		l.Values = append(l.Values, value)
+	}
+	return nil
+}
+
+// A Compound is a TAG_Compound. Entries are kept in the order they were read or first set. The
+// zero value is an empty compound ready to use.
+type Compound struct {
+	names  []string
+	values map[string]Value
+}
+
+// Returns the number of entries in the compound.
+func (c *Compound) Len() int {
+	if c == nil {
+		return 0
+	}
+	return len(c.names)
+}
+
+// Returns the names of the entries in the compound, in order.
+func (c *Compound) Names() []string {
+	if c == nil {
+		return nil
+	}
+	return append([]string(nil), c.names...)
+}
+
+// Returns the value of the entry with the given name, or nil if there is no such entry.
+func (c *Compound) Get(name string) Value {
+	if c == nil {
+		return nil
+	}
+	return c.values[name]
+}
+
+// Sets the value of the entry with the given name. A new entry is added after all existing
+// entries; replacing an entry keeps its position.
+func (c *Compound) Set(name string, value Value) {
+	if value == nil {
+		panic(fmt.Errorf("nbt: Cannot set %#v to nil", name))
+	}
+	if c.values == nil {
+		c.values = make(map[string]Value)
+	}
+	if _, exists := c.values[name]; !exists {
+		c.names = append(c.names, name)
+	}
+	c.values[name] = value
+}
+
+// Removes the entry with the given name, if it exists.
+func (c *Compound) Delete(name string) {
+	if _, exists := c.values[name]; !exists {
+		return
+	}
+	delete(c.values, name)
+	for i, n := range c.names {
+		if n == name {
+			c.names = append(c.names[:i], c.names[i+1:]...)
+			break
+		}
+	}
+}
+
+// The typed getters return false if the entry does not exist or has a different tag.
+
+func (c *Compound) GetByte(name string) (int8, bool) {
+	v, ok := c.Get(name).(Byte)
+	return int8(v), ok
+}
+
+func (c *Compound) GetShort(name string) (int16, bool) {
+	v, ok := c.Get(name).(Short)
+	return int16(v), ok
+}
+
+func (c *Compound) GetInt(name string) (int32, bool) {
+	v, ok := c.Get(name).(Int)
+	return int32(v), ok
+}
+
+func (c *Compound) GetLong(name string) (int64, bool) {
+	v, ok := c.Get(name).(Long)
+	return int64(v), ok
+}
+
+func (c *Compound) GetFloat(name string) (float32, bool) {
+	v, ok := c.Get(name).(Float)
+	return float32(v), ok
+}
+
+func (c *Compound) GetDouble(name string) (float64, bool) {
+	v, ok := c.Get(name).(Double)
+	return float64(v), ok
+}
+
+func (c *Compound) GetByteArray(name string) ([]byte, bool) {
+	v, ok := c.Get(name).(ByteArray)
+	return []byte(v), ok
+}
+
+func (c *Compound) GetString(name string) (string, bool) {
+	v, ok := c.Get(name).(String)
+	return string(v), ok
+}
+
+func (c *Compound) GetList(name string) (*List, bool) {
+	v, ok := c.Get(name).(*List)
+	return v, ok
+}
+
+func (c *Compound) GetCompound(name string) (*Compound, bool) {
+	v, ok := c.Get(name).(*Compound)
+	return v, ok
+}
+
+func (c *Compound) GetIntArray(name string) ([]int32, bool) {
+	v, ok := c.Get(name).(IntArray)
+	return []int32(v), ok
+}
+
+func (c *Compound) GetLongArray(name string) ([]int64, bool) {
+	v, ok := c.Get(name).(LongArray)
+	return []int64(v), ok
+}
+
+func (c *Compound) SetByte(name string, v int8)          { c.Set(name, Byte(v)) }
+func (c *Compound) SetShort(name string, v int16)        { c.Set(name, Short(v)) }
+func (c *Compound) SetInt(name string, v int32)          { c.Set(name, Int(v)) }
+func (c *Compound) SetLong(name string, v int64)         { c.Set(name, Long(v)) }
+func (c *Compound) SetFloat(name string, v float32)      { c.Set(name, Float(v)) }
+func (c *Compound) SetDouble(name string, v float64)     { c.Set(name, Double(v)) }
+func (c *Compound) SetByteArray(name string, v []byte)   { c.Set(name, ByteArray(v)) }
+func (c *Compound) SetString(name string, v string)      { c.Set(name, String(v)) }
+func (c *Compound) SetList(name string, v *List)         { c.Set(name, v) }
+func (c *Compound) SetCompound(name string, v *Compound) { c.Set(name, v) }
+func (c *Compound) SetIntArray(name string, v []int32)   { c.Set(name, IntArray(v)) }
+func (c *Compound) SetLongArray(name string, v []int64)  { c.Set(name, LongArray(v)) }
+
+var (
+	valueType    = reflect.TypeOf((*Value)(nil)).Elem()
+	compoundType = reflect.TypeOf(Compound{})
+)
+
+// Reports whether values of type t are decoded with readTree.
+func isTreeType(t reflect.Type) bool {
+	return t == valueType || t.Implements(valueType) || reflect.PtrTo(t).Implements(valueType)
+}
+
+// Returns v as a Value if its type is one of the tree types.
+func treeValue(v reflect.Value) (Value, bool) {
+	if !v.IsValid() || !v.CanInterface() {
+		return nil, false
+	}
+	if value, ok := v.Interface().(Value); ok {
+		return value, value != nil
+	}
+	if v.Kind() != reflect.Ptr && reflect.PtrTo(v.Type()).Implements(valueType) {
+		if v.CanAddr() {
+			return v.Addr().Interface().(Value), true
+		}
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		return p.Interface().(Value), true
+	}
+	return nil, false
+}
+
+// Returns v if it is a tree value, or else the tree Unmarshal would give for the NBT Marshal
+// writes for v, so that v follows every rule Marshal does.
+func toTree(v interface{}) (Value, error) {
+	if value, ok := v.(Value); ok {
+		return value, nil
+	}
+
+	var buf bytes.Buffer
+	if err := Marshal(Uncompressed, &buf, v); err != nil {
+		return nil, err
+	}
+	var value Value
+	if err := Unmarshal(Uncompressed, &buf, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// Reads the payload of a tag into the matching tree type.
+func (d *decodeState) readTree(tag Tag) Value {
+	switch tag {
+	case TAG_Byte:
+		return Byte(d.readByte())
+
+	case TAG_Short:
+		return Short(d.readUint16())
+
+	case TAG_Int:
+		return Int(d.readInt(tag))
+
+	case TAG_Long:
+		return Long(d.readInt(tag))
+
+	case TAG_Float:
+		return Float(d.readFloat(tag))
+
+	case TAG_Double:
+		return Double(d.readFloat(tag))
+
+	case TAG_Byte_Array:
+		length := d.readLength()
+		d.checkLength(tag, length)
+		return ByteArray(d.readBytes(length))
+
+	case TAG_String:
+		return String(d.readString())
+
+	case TAG_List:
+		inner := Tag(d.readByte())
+		length := d.readLength()
+		d.checkLength(tag, length)
+
+		d.enter()
+		defer d.leave()
+
+		list := &List{Elem: inner, Values: make([]Value, 0, preallocLength(length))}
+
+		var i uint32
+		defer func() {
+			if r := recover(); r != nil {
+				d.panicAt(r, indexSegment(int(i)))
+			}
+		}()
+
+		for i = 0; i < length; i++ {
+			list.Values = append(list.Values, d.readTree(inner))
+		}
+		return list
+
+	case TAG_Compound:
+		d.enter()
+		defer d.leave()
+
+		compound := new(Compound)
+
+		var name string
+		defer func() {
+			if r := recover(); r != nil {
+				d.panicAt(r, fieldSegment(name))
+			}
+		}()
+
+		for {
+			var tag Tag
+			name, tag = d.readTag()
+			if tag == TAG_End {
+				break
+			}
+			compound.Set(name, d.readTree(tag))
+		}
+		return compound
+
+	case TAG_Int_Array:
+		length := d.readLength()
+		d.checkLength(tag, length)
+		value := make(IntArray, 0, preallocLength(length))
+		for i := uint32(0); i < length; i++ {
+			value = append(value, int32(d.readInt(TAG_Int)))
+		}
+		return value
+
+	case TAG_Long_Array:
+		length := d.readLength()
+		d.checkLength(tag, length)
+		value := make(LongArray, 0, preallocLength(length))
+		for i := uint32(0); i < length; i++ {
+			value = append(value, d.readInt(TAG_Long))
+		}
+		return value
+	}
+	panic(d.errorf("nbt: Unhandled tag: %s", tag))
+}
+
+// Reads the payload of a tag into v, which must be of one of the tree types.
+func (d *decodeState) readTreeValue(tag Tag, v reflect.Value) {
+	value := reflect.ValueOf(d.readTree(tag))
+	if value.Type().AssignableTo(v.Type()) {
+		v.Set(value)
+	} else if value.Kind() == reflect.Ptr && value.Type().Elem() == v.Type() {
+		v.Set(value.Elem())
+	} else {
+		panic(d.typeError(tag, v))
+	}
+}
+
+// Writes the payload of a tree value.
+func (e *encodeState) writeTree(v Value) {
+	switch v := v.(type) {
+	case Byte:
+		e.writeByte(byte(v))
+
+	case Short:
+		e.writeUint16(uint16(v))
+
+	case Int:
+		e.writeInt(TAG_Int, uint64(v))
+
+	case Long:
+		e.writeInt(TAG_Long, uint64(v))
+
+	case Float:
+		e.writeFloat(TAG_Float, float64(v))
+
+	case Double:
+		e.writeFloat(TAG_Double, float64(v))
+
+	case ByteArray:
+		e.writeLength(len(v))
+		e.write(v)
+
+	case String:
+		e.writeString(string(v))
+
+	case *List:
+		if v == nil {
+			panic(fmt.Errorf("nbt: Unhandled nil value"))
+		}
+		e.writeByte(byte(v.Elem))
+		e.writeLength(v.Len())
+
+		var i int
+		defer func() {
+			if r := recover(); r != nil {
+				panicAt(r, indexSegment(i))
+			}
+		}()
+
+		for i = 0; i < v.Len(); i++ {
+			if v.Values[i] == nil {
+				panic(fmt.Errorf("nbt: Unhandled nil value"))
+			}
+			if tag := v.Values[i].Tag(); tag != v.Elem {
+				panic(fmt.Errorf("nbt: Found a %s in a list of %s", tag, v.Elem))
+			}
+			e.writeTree(v.Values[i])
+		}
+
+	case *Compound:
+		if v == nil {
+			panic(fmt.Errorf("nbt: Unhandled nil value"))
+		}
+		for _, name := range v.Names() {
+			e.writeTreeTag(name, v.values[name])
+		}
+		e.writeByte(byte(TAG_End))
+
+	case IntArray:
+		e.writeLength(len(v))
+		for _, x := range v {
+			e.writeInt(TAG_Int, uint64(x))
+		}
+
+	case LongArray:
+		e.writeLength(len(v))
+		for _, x := range v {
+			e.writeInt(TAG_Long, uint64(x))
+		}
+
+	default:
+		panic(unhandledType(reflect.TypeOf(v), "nbt: Unhandled type: %T", v))
+	}
+}
+
+func (e *encodeState) writeTreeTag(name string, v Value) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicAt(r, fieldSegment(name))
+		}
+	}()
+
+	e.writeHeader(v.Tag(), name)
+	e.writeTree(v)
+}
+
+// Writes a slice of tree values, such as a []Value or a []*Compound, as a list.
+func (e *encodeState) writeTreeList(v reflect.Value) {
+	values := make([]Value, v.Len())
+	for i := range values {
+		value, ok := treeValue(v.Index(i))
+		if !ok {
+			panicAt(fmt.Errorf("nbt: Unhandled nil value"), indexSegment(i))
+		}
+		values[i] = value
+	}
+
+	list := &List{Values: values}
+	if len(values) != 0 {
+		list.Elem = values[0].Tag()
+	}
+	e.writeTree(list)
+}
@@ -0,0 +1,173 @@
+package nbt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func readTestcase(t testing.TB, name string, compression Compression) []byte {
+	f, err := os.Open("testcases/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if compression == Uncompressed {
+		data, err := ioutil.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestTreeRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		compression Compression
+	}{
+		{"servers.dat", Uncompressed},
+		{"bigtest.nbt", GZip},
+		{"Nightgunner5.dat", GZip},
+		{"longarraytest.nbt", GZip},
+	} {
+		data := readTestcase(t, test.name, test.compression)
+
+		var root *Compound
+		if err := NewDecoder(bytes.NewReader(data)).Decode(&root); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		var encoded bytes.Buffer
+		enc := NewEncoder(&encoded)
+		enc.SetRootName(rootName(data))
+		if err := enc.Encode(root); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		enc.Flush()
+
+		if !bytes.Equal(encoded.Bytes(), data) {
+			t.Errorf("%s: Re-encoded tree differs from the original", test.name)
+		}
+	}
+}
+
+func rootName(data []byte) string {
+	length := int(data[1])<<8 | int(data[2])
+	return string(data[3 : 3+length])
+}
+
+func TestTreeAccess(t *testing.T) {
+	var root Value
+	err := Unmarshal(Uncompressed, bytes.NewReader(readTestcase(t, "bigtest.nbt", GZip)), &root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	level, ok := root.(*Compound)
+	if !ok {
+		t.Fatalf("Root is a %T, but expected *Compound", root)
+	}
+
+	if v, ok := level.GetShort("shortTest"); !ok || v != 32767 {
+		t.Errorf("shortTest == %d, %v", v, ok)
+	}
+	if _, ok := level.GetInt("shortTest"); ok {
+		t.Error("GetInt succeeded for a TAG_Short")
+	}
+	if nested, ok := level.GetCompound("nested compound test"); !ok {
+		t.Error("nested compound test is missing")
+	} else if egg, ok := nested.GetCompound("egg"); !ok {
+		t.Error("egg is missing")
+	} else if name, _ := egg.GetString("name"); name != "Eggbert" {
+		t.Errorf("egg name == %#v", name)
+	}
+	if list, ok := level.GetList("listTest (long)"); !ok || list.Elem != TAG_Long || list.Len() != 5 || list.Get(4) != Long(15) {
+		t.Errorf("listTest (long) == %#v", list)
+	}
+
+	names := level.Names()
+	level.SetShort("shortTest", -1)
+	level.SetString("added", "at the end")
+	level.Delete("byteTest")
+	if v, _ := level.GetShort("shortTest"); v != -1 {
+		t.Errorf("shortTest == %d after SetShort", v)
+	}
+	newNames := level.Names()
+	if len(newNames) != len(names) || newNames[len(newNames)-1] != "added" {
+		t.Errorf("Names after editing: %v", newNames)
+	}
+
+	list := &List{}
+	if err := list.Append(Int(1), Int(2)); err != nil {
+		t.Error(err)
+	}
+	if err := list.Append(Long(3)); err == nil {
+		t.Error("Appending a TAG_Long to a list of TAG_Int succeeded")
+	}
+}
+
+func TestTreeEmptyList(t *testing.T) {
+	root := new(Compound)
+	root.SetList("empty", &List{Elem: TAG_Compound})
+	root.Set("values", Byte(1))
+
+	var encoded bytes.Buffer
+	if err := Marshal(Uncompressed, &encoded, root); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded *Compound
+	if err := Unmarshal(Uncompressed, &encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if list, ok := decoded.GetList("empty"); !ok || list.Elem != TAG_Compound || list.Len() != 0 {
+		t.Errorf("empty == %#v", list)
+	}
+	if names := decoded.Names(); len(names) != 2 || names[0] != "empty" || names[1] != "values" {
+		t.Errorf("Names == %v", names)
+	}
+}
+
+func TestTreeNil(t *testing.T) {
+	inner := new(Compound)
+	inner.Set("list", (*List)(nil))
+	withList := new(Compound)
+	withList.SetCompound("inner", inner)
+
+	withCompound := new(Compound)
+	withCompound.SetList("list", &List{Elem: TAG_Compound, Values: []Value{new(Compound), (*Compound)(nil)}})
+
+	withNil := new(Compound)
+	withNil.SetList("list", &List{Elem: TAG_Int, Values: []Value{nil}})
+
+	for _, test := range []struct {
+		root     *Compound
+		expected string
+	}{
+		{withList, "nbt: Unhandled nil value\n\t\tat struct field \"list\"\n\t\tat struct field \"inner\""},
+		{withCompound, "nbt: Unhandled nil value\n\t\tat list index 1\n\t\tat struct field \"list\""},
+		{withNil, "nbt: Unhandled nil value\n\t\tat list index 0\n\t\tat struct field \"list\""},
+	} {
+		var encoded bytes.Buffer
+		err := Marshal(Uncompressed, &encoded, test.root)
+		if _, ok := err.(*EncodeError); !ok || err.Error() != test.expected {
+			t.Errorf("Error is %#v, but expected %q", err, test.expected)
+		}
+	}
+}